@@ -1,6 +1,9 @@
 package main
 
 import (
+	"log"
+	"math"
+
 	r "github.com/go-pathtracer/renderer"
 )
 
@@ -9,12 +12,31 @@ func main() {
 		Width:  800,
 		Height: 600,
 		//Fov:    90,
-		Fov:      54.36,
-		MaxDepth: 5,
+		Fov:             54.36,
+		MaxDepth:        5,
+		LightSampling:   r.SampleLightsBVH,
+		SamplesPerPixel: 16,
+		//SampleGenerator: r.SobolSamples,
+		//Roulette:        r.RouletteThroughput,
+		//PixelEstimator:  r.PixelMedianOfMeans,
+		//Filter:          r.FilterMitchell,
+		//AdaptiveThreshold: .05,
+		//Passes:            8,
+		//TimeBudget:        time.Minute,
+		//TargetNoise:       .02,
+		//TileOrder:         r.TileHilbert,
+		//Seed:            1,
 	}
 
 	sampler := r.PathTracer{}
-	//sampler := r.RayTracer{}
+	//sampler := r.PathTracer{Guiding: true}
+	//sampler := r.PathTracer{IrradianceCache: true}
+	//sampler := r.RayTracer{Background: r.NewVector3(1, 1, 1)}
+	//sampler := r.BDPT{}
+	//sampler := r.SPPM{}
+	//sampler := r.MLT{}
+	//sampler := r.DebugTracer{Mode: r.DebugObjectID}
+	//sampler := r.AmbientOcclusion{MaxDistance: 30}
 
 	camera, scene := createCornellBoxScene2()
 
@@ -45,7 +67,8 @@ func createCornellBoxScene2() (r.Camera, r.Scene) {
 		r.CreateSphere(r.NewVector3(27, 16.5, 47), 16.5, mirrorMaterial), // Sphere1
 		r.CreateSphere(r.NewVector3(73, 16.5, 78), 16.5, glassMaterial),  // Sphere2
 
-		r.CreateSphere(r.NewVector3(50, 81.6-16.5, 81.6), 1.5, lightMaterial), // Light
+		// Hidden from the camera, as the path tracer used to skip emitters on camera rays
+		r.CreateSphere(r.NewVector3(50, 81.6-16.5, 81.6), 1.5, lightMaterial).WithVisibility(r.HiddenFromCamera), // Light
 	}
 
 	lights := []r.Light{}
@@ -56,6 +79,68 @@ func createCornellBoxScene2() (r.Camera, r.Scene) {
 	return camera, r.Scene{Objects: objects, Lights: lights}
 }
 
+// Same as createCornellBoxScene2, lit by an emissive quad instead of a small sphere
+func createCornellBoxMeshLightScene() (r.Camera, r.Scene) {
+	camera, scene := createCornellBoxScene2()
+
+	lightMaterial := r.NewMaterial(r.Vector3Zero, 0, 0, r.NewVector3(1, 1, 1).MulScalar(12))
+	quad, err := r.CreateMesh(
+		[]r.Vector3{
+			r.NewVector3(40, 81.5, 72),
+			r.NewVector3(60, 81.5, 72),
+			r.NewVector3(60, 81.5, 92),
+			r.NewVector3(40, 81.5, 92),
+		},
+		[][3]int{{0, 1, 2}, {0, 2, 3}},
+		lightMaterial)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Replace the light sphere
+	scene.Objects[len(scene.Objects)-1] = quad.WithVisibility(r.HiddenFromCamera)
+
+	return camera, scene
+}
+
+func createFoggyCornellBoxScene() (r.Camera, r.Scene) {
+	camera, scene := createCornellBoxScene2()
+
+	// Light fog in the whole box and murky water in a ball
+	scene.Medium = r.NewHomogeneousMedium(r.NewVector3(1, 1, 1).MulScalar(.001), r.NewVector3(1, 1, 1).MulScalar(.004), .5)
+
+	water := r.NewHomogeneousMedium(r.NewVector3(.02, .006, .004), r.NewVector3(.02, .03, .03), .8)
+	scene.Objects = append(scene.Objects, r.CreateSphere(r.NewVector3(50, 12, 100), 12, r.NewMediumBoundary(water)))
+
+	return camera, scene
+}
+
+func createSmokeCornellBoxScene() (r.Camera, r.Scene) {
+	camera, scene := createCornellBoxScene2()
+
+	// Procedural cloud with a glowing core, a grid could also be read with r.LoadVoxelGrid
+	const resolution = 32
+	grid := r.NewVoxelGrid(resolution, resolution, resolution)
+	for z := 0; z < resolution; z++ {
+		for y := 0; y < resolution; y++ {
+			for x := 0; x < resolution; x++ {
+				p := r.NewVector3(float64(x), float64(y), float64(z)).MulScalar(1. / resolution).Sub(r.NewVector3(.5, .5, .5))
+				noise := .5 + .25*math.Sin(p.X*23)*math.Sin(p.Y*19+1)*math.Sin(p.Z*17+2)
+				falloff := math.Max(0, 1-p.Length()*2)
+
+				grid.SetDensity(x, y, z, noise*falloff)
+				grid.SetEmission(x, y, z, math.Max(0, 1-p.Length()*5))
+			}
+		}
+	}
+
+	bounds := r.NewAABB(r.NewVector3(30, 10, 60), r.NewVector3(70, 50, 100))
+	smoke := r.NewGridMedium(grid, bounds, r.NewVector3(.1, .1, .1), r.NewVector3(.5, .5, .5), .3, r.NewVector3(4, 1.5, .4))
+	scene.Objects = append(scene.Objects, smoke.Proxy())
+
+	return camera, scene
+}
+
 func createCornellBoxScene() (r.Camera, r.Scene) {
 	lightMaterial := r.NewMaterial(r.Vector3Zero, 0, 0, r.NewVector3(1, 1, 1).MulScalar(4))
 	leftWallMaterial := r.NewMaterial(r.NewVector3(.75, .25, .25), 0, 0, r.Vector3Zero)
@@ -82,7 +167,8 @@ func createCornellBoxScene() (r.Camera, r.Scene) {
 		r.CreateSphere(r.NewVector3(50, -75, -offsetZ-200), 30, mirrorMaterial),  // Sphere2
 		r.CreateSphere(r.NewVector3(-15, -75, -offsetZ-190), 30, glassMaterial),  // Sphere3
 
-		r.CreateSphere(r.NewVector3(0, offsetX, -offsetZ-150), 30, lightMaterial), // Light
+		// Hidden from the camera, as the path tracer used to skip emitters on camera rays
+		r.CreateSphere(r.NewVector3(0, offsetX, -offsetZ-150), 30, lightMaterial).WithVisibility(r.HiddenFromCamera), // Light
 	}
 
 	lights := []r.Light{
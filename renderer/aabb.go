@@ -0,0 +1,77 @@
+package renderer
+
+import "math"
+
+// AABB is an axis-aligned bounding box
+type AABB struct {
+	Min Vector3
+	Max Vector3
+}
+
+// EmptyAABB contains nothing; the union of it with any box is that box
+var EmptyAABB = AABB{
+	Min: NewVector3(math.MaxFloat64, math.MaxFloat64, math.MaxFloat64),
+	Max: NewVector3(-math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64),
+}
+
+func NewAABB(a, b Vector3) AABB {
+	return AABB{Min: a.Min(b), Max: a.Max(b)}
+}
+
+func (b AABB) Union(b2 AABB) AABB {
+	return AABB{Min: b.Min.Min(b2.Min), Max: b.Max.Max(b2.Max)}
+}
+
+func (b AABB) Extend(p Vector3) AABB {
+	return AABB{Min: b.Min.Min(p), Max: b.Max.Max(p)}
+}
+
+func (b AABB) Center() Vector3 {
+	return b.Min.Add(b.Max).MulScalar(.5)
+}
+
+func (b AABB) Diagonal() Vector3 {
+	return b.Max.Sub(b.Min)
+}
+
+// LargestAxis returns 0, 1 or 2 for X, Y or Z
+func (b AABB) LargestAxis() int {
+	d := b.Diagonal()
+	if d.X > d.Y && d.X > d.Z {
+		return 0
+	} else if d.Y > d.Z {
+		return 1
+	}
+	return 2
+}
+
+// BoundingSphere returns the center and radius of a sphere enclosing the box
+func (b AABB) BoundingSphere() (Vector3, float64) {
+	center := b.Center()
+	return center, center.DistanceTo(b.Max)
+}
+
+// IntersectRay returns the parametric range over which the ray is inside the box (slab test)
+func (b AABB) IntersectRay(r Ray) (tMin, tMax float64, ok bool) {
+	tMin = 0
+	tMax = math.MaxFloat64
+
+	for axis := 0; axis < 3; axis++ {
+		origin := r.Origin.Axis(axis)
+		invDirection := 1 / r.Direction.Axis(axis)
+
+		t0 := (b.Min.Axis(axis) - origin) * invDirection
+		t1 := (b.Max.Axis(axis) - origin) * invDirection
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+
+		tMin = math.Max(tMin, t0)
+		tMax = math.Min(tMax, t1)
+		if tMin > tMax {
+			return 0, 0, false
+		}
+	}
+
+	return tMin, tMax, true
+}
@@ -0,0 +1,74 @@
+package renderer
+
+import "math/rand"
+
+// AmbientOcclusion shades the first surface seen through each pixel by how much of its hemisphere is left
+// open, ignoring materials and lights. Each sample jitters the camera ray and casts one cosine-weighted
+// ray from the surface, the surface being open in directions where nothing is hit within MaxDistance.
+type AmbientOcclusion struct {
+	MaxDistance float64 // A tenth of the size of the visible part of the scene when zero
+
+	maxDistance float64
+}
+
+const defaultAODistance = .1
+
+// Passes is zero, the render staying progressive
+func (r AmbientOcclusion) Passes() int {
+	return 0
+}
+
+// BeginPass resolves the default distance once, as rays always hit something in closed scenes
+func (r AmbientOcclusion) BeginPass(pass int, camera Camera, scene Scene, options RenderingOptions) Sampler {
+	if r.maxDistance == 0 {
+		r.maxDistance = r.MaxDistance
+		if r.maxDistance <= 0 {
+			r.maxDistance = scene.visibleBounds(camera, options).Diagonal().Length() * defaultAODistance
+		}
+	}
+	return r
+}
+
+func (r AmbientOcclusion) Sample(x, y uint, camera Camera, scene Scene, options RenderingOptions, rnd *rand.Rand) Vector3 {
+	spp := options.samplesPerPixel()
+	strata := options.strataPerSide() * options.strataPerSide()
+
+	samples := options.newPixelSamples(x, y, spp, rnd)
+	rnd = rand.New(samples)
+
+	open := 0
+	index := options.pass * spp
+	for stratum := 0; stratum < strata; stratum++ {
+		for i := 0; i < options.stratumSamples(stratum); i++ {
+			samples.startSample(index)
+			index++
+
+			if r.open(x, y, stratum, camera, scene, options, rnd) {
+				open++
+			}
+		}
+	}
+
+	ao := float64(open) / float64(spp)
+	return NewVector3(ao, ao, ao)
+}
+
+// open tells whether a cosine-weighted ray leaves the surface seen through a cell of the pixel without being occluded
+func (r AmbientOcclusion) open(x, y uint, stratum int, camera Camera, scene Scene, options RenderingOptions, rnd *rand.Rand) bool {
+	rasterX, rasterY := options.pixelSample(x, y, stratum, rnd)
+	direction := camera.rayDirection(rasterX, rasterY, options)
+	hit, index := scene.firstSurface(NewRay(camera.position, direction), HiddenFromCamera)
+	if index == -1 {
+		return true
+	}
+
+	// Hemisphere on the side of the camera
+	normal := hit.Normal
+	if normal.Dot(direction) > 0 {
+		normal = normal.MulScalar(-1)
+	}
+
+	wi := cosineSampleHemisphere(normal, rnd.Float64(), rnd.Float64())
+	occluder, occluderIndex := scene.firstSurface(NewRay(offsetRayOrigin(hit.Position, normal, wi), wi), HiddenFromShadows)
+	return occluderIndex == -1 || occluder.Distance > r.maxDistance
+}
@@ -0,0 +1,623 @@
+package renderer
+
+import (
+	"math"
+	"math/rand"
+)
+
+// BDPT is a bidirectional path tracer: for every sample it traces a subpath from the camera and one from
+// a light, connects all pairs of their vertices and weights each strategy with multiple importance sampling.
+// Connections to the camera land on other pixels and are splatted onto the film.
+// See: https://pbr-book.org/3ed-2018/Light_Transport_III_Bidirectional_Methods/Bidirectional_Path_Tracing
+type BDPT struct {
+	film *Film
+}
+
+func (r BDPT) WithFilm(film *Film) Sampler {
+	r.film = film
+	return r
+}
+
+type vertexKind uint8
+
+const (
+	cameraVertex vertexKind = iota
+	lightVertex
+	surfaceVertex
+)
+
+type pathVertex struct {
+	kind   vertexKind
+	point  Vector3
+	normal Vector3 // Geometric normal, zero for the camera and point lights
+	wo     Vector3 // Direction towards the previous vertex of the subpath
+	beta   Vector3 // Throughput from the start of the subpath
+	bsdf   bsdf
+	object int // Index in Scene.Objects of surface vertices
+
+	emission   Vector3 // Emitted radiance of lights and emissive surfaces
+	twoSided   bool    // Whether the emission happens on both sides of the surface
+	emitter    emitter // Set for light vertices
+	light      LightID // Set for light vertices
+	deltaLight bool
+
+	delta  bool    // Scattered by a delta BSDF
+	pdfFwd float64 // Area density of the vertex, sampled from the previous one
+	pdfRev float64 // Area density of the vertex, if it had been sampled from the next one
+}
+
+func (v *pathVertex) isConnectible() bool {
+	if v.kind == surfaceVertex {
+		return !v.bsdf.isDelta()
+	}
+	return true
+}
+
+func (v *pathVertex) isLight() bool {
+	return v.kind == lightVertex || (v.kind == surfaceVertex && !v.emission.IsZero())
+}
+
+// le returns the radiance emitted by the vertex towards w
+func (v *pathVertex) le(w Vector3) Vector3 {
+	if !v.isLight() || (!v.twoSided && v.normal.Dot(w) <= 0) {
+		return Vector3Zero
+	}
+	return v.emission
+}
+
+// f evaluates the BSDF of the vertex for light going to the next vertex
+func (v *pathVertex) f(next *pathVertex) Vector3 {
+	if v.kind != surfaceVertex {
+		return Vector3Zero
+	}
+
+	wi := next.point.Sub(v.point)
+	if wi.IsZero() {
+		return Vector3Zero
+	}
+
+	return v.bsdf.eval(v.wo, wi.Normalize())
+}
+
+// convertDensity turns a solid angle density at the vertex into an area density at the next one
+func (v *pathVertex) convertDensity(pdf float64, next *pathVertex) float64 {
+	w := next.point.Sub(v.point)
+	distanceSquare := w.LengthSquared()
+	if distanceSquare == 0 {
+		return 0
+	}
+
+	if !next.normal.IsZero() {
+		pdf *= math.Abs(next.normal.Dot(w.MulScalar(1 / math.Sqrt(distanceSquare))))
+	}
+
+	return pdf / distanceSquare
+}
+
+// emissionPdf returns the solid angle density with which the light at the vertex emits towards w
+func (v *pathVertex) emissionPdf(w Vector3) float64 {
+	// Point lights
+	if v.normal.IsZero() {
+		return 1 / (4 * math.Pi)
+	}
+
+	cos := v.normal.Dot(w)
+	if v.twoSided {
+		return math.Abs(cos) / (2 * math.Pi)
+	}
+	if cos <= 0 {
+		return 0
+	}
+
+	return cos * M_1_PI
+}
+
+// bdptContext holds what the strategies need to evaluate densities
+type bdptContext struct {
+	camera  Camera
+	scene   Scene
+	options RenderingOptions
+	lights  *lightSet
+	rnd     *rand.Rand
+}
+
+// pdf returns the area density of sampling next from the vertex, which was itself reached from prev
+func (c *bdptContext) pdf(v, prev, next *pathVertex) float64 {
+	if v.kind == lightVertex {
+		return c.pdfLight(v, next)
+	}
+
+	wn := next.point.Sub(v.point)
+	if wn.IsZero() {
+		return 0
+	}
+	wn = wn.Normalize()
+
+	var pdf float64
+	if v.kind == cameraVertex {
+		_, pdf = c.camera.importance(wn, c.options)
+	} else {
+		pdf = v.bsdf.pdf(prev.point.Sub(v.point).Normalize(), wn)
+	}
+
+	return v.convertDensity(pdf, next)
+}
+
+// pdfLight returns the area density of a light path leaving the light at v reaching the next vertex
+func (c *bdptContext) pdfLight(v, next *pathVertex) float64 {
+	w := next.point.Sub(v.point)
+	distanceSquare := w.LengthSquared()
+	if distanceSquare == 0 {
+		return 0
+	}
+	w = w.Normalize()
+
+	pdf := v.emissionPdf(w) / distanceSquare
+	if !next.normal.IsZero() {
+		pdf *= math.Abs(next.normal.Dot(w))
+	}
+
+	return pdf
+}
+
+// pdfLightOrigin returns the area density of starting a light path at the vertex
+func (c *bdptContext) pdfLightOrigin(v *pathVertex) float64 {
+	if c.lights.totalPower == 0 {
+		return 0
+	}
+
+	if v.deltaLight {
+		return v.emitter.power() / c.lights.totalPower
+	}
+
+	// Emitters are picked according to their power, PI * area * luminance, then a point uniformly over their area
+	return math.Pi * v.emission.Luminance() / c.lights.totalPower
+}
+
+// visible tells whether nothing stands between two vertices
+func (c *bdptContext) visible(a, b *pathVertex, hidden Visibility) bool {
+	direction := b.point.Sub(a.point).Normalize()
+	origin := offsetRayOrigin(a.point, a.normal, direction)
+	distance := b.point.DistanceTo(origin)
+
+	hit, _ := c.scene.intersect(NewRay(origin, direction), hidden)
+	return !hit.Valid || hit.Distance > distance*(1-1e-4)
+}
+
+// illuminates tells whether the light at a vertex lights the next vertex of the path directly, according to the light links
+func (c *bdptContext) illuminates(light, next *pathVertex) bool {
+	if next.kind != surfaceVertex {
+		return true
+	}
+
+	id := light.light
+	if light.kind == surfaceVertex {
+		id = EmissiveObject(light.object)
+	}
+	return c.scene.illuminates(id, next.object)
+}
+
+func (r BDPT) Sample(x, y uint, camera Camera, scene Scene, options RenderingOptions, rnd *rand.Rand) Vector3 {
+	spp := options.samplesPerPixel()
+	strata := options.strataPerSide() * options.strataPerSide()
+
+	samples := options.newPixelSamples(x, y, spp, rnd)
+	c := &bdptContext{camera: camera, scene: scene, options: options, lights: scene.lightSet(options), rnd: rand.New(samples)}
+	weight := options.exposureScale() / float64(spp)
+
+	pixelColor := NewVector3(0, 0, 0)
+	index := options.pass * spp
+	for stratum := 0; stratum < strata; stratum++ {
+		for i := 0; i < options.stratumSamples(stratum); i++ {
+			samples.startSample(index)
+			index++
+			rasterX, rasterY := options.pixelSample(x, y, stratum, c.rnd)
+
+			sampleColor := r.sample(c, rasterX, rasterY, weight)
+			pixelColor = pixelColor.Add(sampleColor.MulScalar(weight))
+			if r.film != nil {
+				r.film.AddSample(rasterX, rasterY, sampleColor.MulScalar(options.exposureScale()))
+			}
+		}
+	}
+
+	return pixelColor
+}
+
+// sample connects the subpaths of a camera sample and returns the radiance reaching it, splatting the
+// connections to the camera with the given weight
+func (r BDPT) sample(c *bdptContext, rasterX, rasterY float64, weight float64) Vector3 {
+	maxDepth := int(c.options.maxDepth())
+	cameraPath := r.cameraSubpath(c, rasterX, rasterY, maxDepth+2)
+	lightPath := r.lightSubpath(c, maxDepth+1)
+	sampleColor := NewVector3(0, 0, 0)
+
+	for t := 1; t <= len(cameraPath); t++ {
+		for s := 0; s <= len(lightPath); s++ {
+			depth := t + s - 2
+			if (s == 1 && t == 1) || depth < 0 || depth > maxDepth {
+				continue
+			}
+
+			contribution, splatX, splatY := r.connect(c, lightPath, cameraPath, s, t)
+			if contribution.IsZero() {
+				continue
+			}
+
+			if t == 1 {
+				if r.film != nil {
+					r.film.Splat(splatX, splatY, contribution.MulScalar(weight))
+				}
+			} else {
+				sampleColor = sampleColor.Add(contribution)
+			}
+		}
+	}
+
+	return sampleColor
+}
+
+func (r BDPT) cameraSubpath(c *bdptContext, rasterX, rasterY float64, maxVertices int) []pathVertex {
+	direction := c.camera.rayDirection(rasterX, rasterY, c.options)
+	_, pdfDir := c.camera.importance(direction, c.options)
+
+	path := []pathVertex{{
+		kind:   cameraVertex,
+		point:  c.camera.position,
+		beta:   NewVector3(1, 1, 1),
+		pdfFwd: 1,
+	}}
+
+	return r.randomWalk(c, NewRay(c.camera.position, direction), NewVector3(1, 1, 1), pdfDir, maxVertices, path, HiddenFromCamera)
+}
+
+func (r BDPT) lightSubpath(c *bdptContext, maxVertices int) []pathVertex {
+	i, pmf := c.lights.alias.sample(c.rnd.Float64())
+	if i < 0 || pmf == 0 {
+		return nil
+	}
+
+	e := c.lights.emitters[i]
+	sample := e.sampleEmission(c.rnd.Float64(), c.rnd.Float64(), c.rnd.Float64(), c.rnd.Float64())
+	if sample.pdfPos == 0 || sample.pdfDir == 0 || sample.radiance.IsZero() {
+		return nil
+	}
+
+	_, deltaLight := e.(pointEmitter)
+	twoSided := false
+	if area, ok := e.(areaEmitter); ok {
+		_, twoSided = area.shape.normalBounds()
+	}
+
+	path := []pathVertex{{
+		kind:       lightVertex,
+		point:      sample.point,
+		normal:     sample.normal,
+		beta:       sample.radiance,
+		emission:   sample.radiance,
+		twoSided:   twoSided,
+		emitter:    e,
+		light:      c.lights.ids[i],
+		deltaLight: deltaLight,
+		pdfFwd:     sample.pdfPos * pmf,
+	}}
+
+	cos := 1.
+	if !sample.normal.IsZero() {
+		cos = math.Abs(sample.normal.Dot(sample.direction))
+	}
+	beta := sample.radiance.MulScalar(cos / (pmf * sample.pdfPos * sample.pdfDir))
+
+	origin := offsetRayOrigin(sample.point, sample.normal, sample.direction)
+	path = r.randomWalk(c, NewRay(origin, sample.direction), beta, sample.pdfDir, maxVertices, path, HiddenFromIndirect)
+
+	// The light does not reach the rest of the path when it is not linked to the first object it hits.
+	// The other strategies reject the same paths in connect, so that the MIS weights still sum to one.
+	if len(path) > 1 && !c.illuminates(&path[0], &path[1]) {
+		path = path[:1]
+	}
+	return path
+}
+
+// randomWalk extends a subpath by sampling the BSDFs at each hit, until it leaves the scene or reaches maxVertices
+func (r BDPT) randomWalk(c *bdptContext, ray Ray, beta Vector3, pdf float64, maxVertices int, path []pathVertex, hidden Visibility) []pathVertex {
+	pdfFwd := pdf
+
+	for len(path) < maxVertices {
+		hit, index := c.scene.intersect(ray, hidden)
+		if !hit.Valid {
+			break
+		}
+
+		obj := c.scene.Objects[index]
+		material := obj.Material()
+		wo := ray.Direction.MulScalar(-1)
+
+		path = append(path, pathVertex{
+			kind:     surfaceVertex,
+			point:    hit.Position,
+			normal:   hit.Normal,
+			wo:       wo,
+			beta:     beta,
+			bsdf:     newBSDF(material, hit.Normal),
+			emission: material.EmissionColor,
+			twoSided: emitsTwoSided(obj),
+			object:   index,
+		})
+		current := len(path) - 1
+		prev := current - 1
+		path[current].pdfFwd = path[prev].convertDensity(pdfFwd, &path[current])
+
+		if len(path) >= maxVertices {
+			break
+		}
+
+		wi, f, pdfSampled, delta := path[current].bsdf.sample(wo, c.rnd.Float64(), c.rnd.Float64(), c.rnd.Float64())
+		if f.IsZero() || pdfSampled == 0 {
+			break
+		}
+
+		beta = beta.Mul(f).MulScalar(math.Abs(wi.Dot(hit.Normal)) / pdfSampled)
+		pdfRev := path[current].bsdf.pdf(wi, wo)
+		pdfFwd = pdfSampled
+		if delta {
+			path[current].delta = true
+			pdfRev = 0
+			pdfFwd = 0
+		}
+		path[prev].pdfRev = path[current].convertDensity(pdfRev, &path[prev])
+
+		ray = NewRay(offsetRayOrigin(hit.Position, hit.Normal, wi), wi)
+		hidden = ternaryVisibility(delta, HiddenFromReflections, HiddenFromIndirect)
+	}
+
+	return path
+}
+
+// connect evaluates the strategy using s vertices of the light subpath and t vertices of the camera subpath.
+// When t is 1, the contribution belongs to the returned raster position.
+func (r BDPT) connect(c *bdptContext, lightPath, cameraPath []pathVertex, s, t int) (Vector3, float64, float64) {
+	L := Vector3Zero
+	var sampled pathVertex
+	rasterX, rasterY := 0., 0.
+
+	switch {
+	case s == 0:
+		// The camera subpath hit a light by itself
+		pt := &cameraPath[t-1]
+		if pt.isLight() && (t < 2 || c.illuminates(pt, &cameraPath[t-2])) {
+			L = pt.beta.Mul(pt.le(pt.wo))
+		}
+
+	case t == 1:
+		// Connect the light subpath to the camera
+		qs := &lightPath[s-1]
+		if !qs.isConnectible() {
+			return Vector3Zero, 0, 0
+		}
+
+		toCamera := c.camera.position.Sub(qs.point)
+		distanceSquare := toCamera.LengthSquared()
+		direction := toCamera.MulScalar(-1).Normalize()
+
+		x, y, ok := c.camera.rasterPosition(direction, c.options)
+		we, _ := c.camera.importance(direction, c.options)
+		if !ok || we == 0 {
+			return Vector3Zero, 0, 0
+		}
+		rasterX, rasterY = x, y
+
+		// Density of the direction at qs, as the pinhole is a point
+		pdf := distanceSquare / direction.Dot(c.camera.direction.Normalize())
+		sampled = pathVertex{kind: cameraVertex, point: c.camera.position, beta: NewVector3(we/pdf, we/pdf, we/pdf)}
+
+		L = qs.beta.Mul(qs.f(&sampled)).Mul(sampled.beta)
+		if qs.kind == surfaceVertex {
+			L = L.MulScalar(math.Abs(direction.Dot(qs.normal)))
+		}
+		if !L.IsZero() && !c.visible(qs, &sampled, HiddenFromCamera) {
+			L = Vector3Zero
+		}
+
+	case s == 1:
+		// Sample a point on a light, as the path tracer does
+		pt := &cameraPath[t-1]
+		if !pt.isConnectible() {
+			return Vector3Zero, 0, 0
+		}
+
+		i, pmf := c.lights.alias.sample(c.rnd.Float64())
+		if i < 0 || pmf == 0 {
+			return Vector3Zero, 0, 0
+		}
+
+		e := c.lights.emitters[i]
+		sample := e.sample(pt.point, c.rnd.Float64(), c.rnd.Float64())
+		if sample.pdf == 0 || sample.radiance.IsZero() {
+			return Vector3Zero, 0, 0
+		}
+
+		_, deltaLight := e.(pointEmitter)
+		twoSided := false
+		if area, ok := e.(areaEmitter); ok {
+			_, twoSided = area.shape.normalBounds()
+		}
+
+		sampled = pathVertex{
+			kind:       lightVertex,
+			point:      sample.point,
+			normal:     sample.normal,
+			beta:       sample.radiance.MulScalar(1 / (sample.pdf * pmf)),
+			emission:   sample.radiance,
+			twoSided:   twoSided,
+			emitter:    e,
+			light:      c.lights.ids[i],
+			deltaLight: deltaLight,
+		}
+		sampled.pdfFwd = c.pdfLightOrigin(&sampled)
+		if !c.illuminates(&sampled, pt) {
+			return Vector3Zero, 0, 0
+		}
+
+		L = pt.beta.Mul(pt.f(&sampled)).Mul(sampled.beta)
+		if pt.kind == surfaceVertex {
+			L = L.MulScalar(math.Abs(sample.direction.Dot(pt.normal)))
+		}
+		if !L.IsZero() && !c.visible(pt, &sampled, HiddenFromShadows) {
+			L = Vector3Zero
+		}
+
+	default:
+		// Connect two vertices in the middle of the path
+		qs := &lightPath[s-1]
+		pt := &cameraPath[t-1]
+		if !qs.isConnectible() || !pt.isConnectible() {
+			return Vector3Zero, 0, 0
+		}
+
+		L = qs.beta.Mul(qs.f(pt)).Mul(pt.f(qs)).Mul(pt.beta)
+		if !L.IsZero() {
+			L = L.MulScalar(c.geometryTerm(qs, pt))
+		}
+	}
+
+	if L.IsZero() {
+		return Vector3Zero, 0, 0
+	}
+
+	return L.MulScalar(r.misWeight(c, lightPath, cameraPath, &sampled, s, t)), rasterX, rasterY
+}
+
+// geometryTerm returns the visibility and cosines between two vertices, over their squared distance
+func (c *bdptContext) geometryTerm(a, b *pathVertex) float64 {
+	d := a.point.Sub(b.point)
+	g := 1 / d.LengthSquared()
+	d = d.Normalize()
+
+	if !a.normal.IsZero() {
+		g *= math.Abs(a.normal.Dot(d))
+	}
+	if !b.normal.IsZero() {
+		g *= math.Abs(b.normal.Dot(d))
+	}
+
+	if !c.visible(a, b, HiddenFromShadows) {
+		return 0
+	}
+
+	return g
+}
+
+// misWeight returns the balance heuristic weight of a strategy, computed from the ratios of the densities
+// of every other strategy that could have produced the same path
+func (r BDPT) misWeight(c *bdptContext, lightPath, cameraPath []pathVertex, sampled *pathVertex, s, t int) float64 {
+	if s+t == 2 {
+		return 1
+	}
+
+	type densities struct {
+		pdfFwd float64
+		pdfRev float64
+		delta  bool
+	}
+
+	// Vertices of the strategy, the endpoint being replaced by the sampled vertex for s == 1 or t == 1
+	lightVertices := make([]*pathVertex, s)
+	for i := range lightVertices {
+		lightVertices[i] = &lightPath[i]
+	}
+	cameraVertices := make([]*pathVertex, t)
+	for i := range cameraVertices {
+		cameraVertices[i] = &cameraPath[i]
+	}
+	if s == 1 {
+		lightVertices[0] = sampled
+	} else if t == 1 {
+		cameraVertices[0] = sampled
+	}
+
+	light := make([]densities, s)
+	for i, v := range lightVertices {
+		light[i] = densities{v.pdfFwd, v.pdfRev, v.delta}
+	}
+	camera := make([]densities, t)
+	for i, v := range cameraVertices {
+		camera[i] = densities{v.pdfFwd, v.pdfRev, v.delta}
+	}
+
+	var qs, pt, qsMinus, ptMinus *pathVertex
+	if s > 0 {
+		qs = lightVertices[s-1]
+	}
+	if t > 0 {
+		pt = cameraVertices[t-1]
+	}
+	if s > 1 {
+		qsMinus = lightVertices[s-2]
+	}
+	if t > 1 {
+		ptMinus = cameraVertices[t-2]
+	}
+
+	// Densities of the connection vertices, in the direction they were not sampled
+	if pt != nil {
+		camera[t-1].delta = false
+		if s > 0 {
+			camera[t-1].pdfRev = c.pdf(qs, qsMinus, pt)
+		} else {
+			camera[t-1].pdfRev = c.pdfLightOrigin(pt)
+		}
+	}
+	if ptMinus != nil {
+		if s > 0 {
+			camera[t-2].pdfRev = c.pdf(pt, qs, ptMinus)
+		} else {
+			camera[t-2].pdfRev = c.pdfLight(pt, ptMinus)
+		}
+	}
+	if qs != nil {
+		light[s-1].delta = false
+		light[s-1].pdfRev = c.pdf(pt, ptMinus, qs)
+	}
+	if qsMinus != nil {
+		light[s-2].pdfRev = c.pdf(qs, pt, qsMinus)
+	}
+
+	remap := func(f float64) float64 {
+		if f == 0 {
+			return 1
+		}
+		return f
+	}
+
+	sumRi := 0.
+
+	ri := 1.
+	for i := t - 1; i > 0; i-- {
+		ri *= remap(camera[i].pdfRev) / remap(camera[i].pdfFwd)
+		if !camera[i].delta && !camera[i-1].delta {
+			sumRi += ri
+		}
+	}
+
+	ri = 1.
+	for i := s - 1; i >= 0; i-- {
+		ri *= remap(light[i].pdfRev) / remap(light[i].pdfFwd)
+		deltaLightVertex := lightVertices[0].deltaLight
+		if i > 0 {
+			deltaLightVertex = light[i-1].delta
+		}
+		if !light[i].delta && !deltaLightVertex {
+			sumRi += ri
+		}
+	}
+
+	return 1 / (1 + sumRi)
+}
+
+func ternaryVisibility(condition bool, a, b Visibility) Visibility {
+	if condition {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,81 @@
+package renderer
+
+import "math"
+
+// Box is an axis-aligned box, mostly used as the proxy geometry delimiting a volume
+type Box struct {
+	bounds     AABB
+	material   Material
+	visibility Visibility
+}
+
+func CreateBox(min, max Vector3, material Material) Box {
+	return Box{bounds: NewAABB(min, max), material: material}
+}
+
+func (b Box) Position() Vector3 {
+	return b.bounds.Center()
+}
+
+func (b Box) Material() Material {
+	return b.material
+}
+
+func (b Box) Visibility() Visibility {
+	return b.visibility
+}
+
+// WithVisibility returns a copy of the box hidden from the given kinds of rays
+func (b Box) WithVisibility(visibility Visibility) Box {
+	b.visibility = visibility
+	return b
+}
+
+func (b Box) Bounds() AABB {
+	return b.bounds
+}
+
+// Intersects returns the nearest face hit by the ray, the exit face when the ray starts inside the box
+func (b Box) Intersects(r Ray) Hit {
+	tMin, tMax, ok := b.bounds.IntersectRay(r)
+	if !ok {
+		return NoHit
+	}
+
+	t := tMin
+	if t <= EPS {
+		t = tMax
+	}
+	if t <= EPS || t == math.MaxFloat64 {
+		return NoHit
+	}
+
+	pHit := r.Origin.Add(r.Direction.MulScalar(t))
+
+	// The face hit is the one the point is the closest to, relatively to the size of the box
+	center := b.bounds.Center()
+	halfSize := b.bounds.Diagonal().MulScalar(.5)
+	axis := 0
+	distance := 0.
+	for a := 0; a < 3; a++ {
+		if halfSize.Axis(a) == 0 {
+			axis = a
+			break
+		}
+		d := math.Abs(pHit.Axis(a)-center.Axis(a)) / halfSize.Axis(a)
+		if d > distance {
+			axis = a
+			distance = d
+		}
+	}
+
+	normal := [3]float64{}
+	normal[axis] = math.Copysign(1, pHit.Axis(axis)-center.Axis(axis))
+
+	// Position on the face, along the two other axes
+	local := pHit.Sub(b.bounds.Min).Div(b.bounds.Diagonal())
+	u := local.Axis((axis + 1) % 3)
+	v := local.Axis((axis + 2) % 3)
+
+	return Hit{Valid: true, Distance: t, Position: pHit, Normal: NewVector3(normal[0], normal[1], normal[2]), U: u, V: v}
+}
@@ -0,0 +1,138 @@
+package renderer
+
+import "math"
+
+// bsdf describes how a material scatters light at a surface point. Directions point away from the surface.
+// Materials without reflectivity nor transparency are diffuse, the others are perfect mirrors or glass.
+type bsdf struct {
+	material Material
+	normal   Vector3 // Geometric normal, on either side of the surface
+}
+
+func newBSDF(material Material, normal Vector3) bsdf {
+	return bsdf{material: material, normal: normal}
+}
+
+// isDelta tells whether the BSDF only scatters in discrete directions, which cannot be connected to
+func (b bsdf) isDelta() bool {
+	return b.material.Reflectivity != 0 || b.material.Transparency != 0
+}
+
+func (b bsdf) eval(wo, wi Vector3) Vector3 {
+	if b.isDelta() || wo.Dot(b.normal)*wi.Dot(b.normal) <= 0 {
+		return Vector3Zero
+	}
+	return b.material.Color.MulScalar(M_1_PI)
+}
+
+func (b bsdf) pdf(wo, wi Vector3) float64 {
+	if b.isDelta() || wo.Dot(b.normal)*wi.Dot(b.normal) <= 0 {
+		return 0
+	}
+	return math.Abs(wi.Dot(b.normal)) * M_1_PI
+}
+
+// sample picks an incident direction for the outgoing one. It returns the direction, the value of the BSDF,
+// the density of the direction and whether it was picked from a delta distribution.
+func (b bsdf) sample(wo Vector3, u1, u2, u3 float64) (Vector3, Vector3, float64, bool) {
+	n := b.normal
+	if wo.Dot(n) < 0 {
+		n = n.MulScalar(-1)
+	}
+
+	// Pure diffuse material
+	if !b.isDelta() {
+		wi := cosineSampleHemisphere(n, u1, u2)
+		return wi, b.material.Color.MulScalar(M_1_PI), wi.Dot(n) * M_1_PI, false
+	}
+
+	cos := wo.Dot(n)
+	reflection := n.MulScalar(2 * cos).Sub(wo)
+
+	// Specular reflection
+	if b.material.Transparency == 0 {
+		return reflection, b.material.Color.MulScalar(1 / cos), 1, true
+	}
+
+	// Reflection + Refraction (dielectric (glass)), chosen according to the Fresnel term
+	into := wo.Dot(b.normal) > 0
+	ior := b.material.ior()
+	nnt := ternaryFloat64(into, 1/ior, ior)
+	cost2t := 1 - nnt*nnt*(1-cos*cos)
+
+	// Total internal reflection
+	if cost2t < 0 {
+		return reflection, b.material.Color.MulScalar(1 / cos), 1, true
+	}
+
+	refraction := wo.MulScalar(-nnt).Add(n.MulScalar(nnt*cos - math.Sqrt(cost2t))).Normalize()
+
+	re := schlick(cos, math.Abs(refraction.Dot(n)), into, ior)
+	if u3 < re {
+		return reflection, b.material.Color.MulScalar(re / cos), re, true
+	}
+
+	cosT := math.Abs(refraction.Dot(n))
+	return refraction, b.material.Color.MulScalar((1 - re) / cosT), 1 - re, true
+}
+
+// schlick approximates the Fresnel reflectance of a dielectric, given the cosines of the incident and transmitted angles
+func schlick(cosI, cosT float64, into bool, ior float64) float64 {
+	a := ior - 1
+	b := ior + 1
+	r0 := a * a / (b * b)
+	c := 1 - ternaryFloat64(into, cosI, cosT)
+	return r0 + (1-r0)*c*c*c*c*c
+}
+
+// fresnelDielectric returns the reflectance of an interface between two dielectrics for unpolarized light.
+// The cosine is taken with the normal on the side of the first medium, negative when the light comes from the other side.
+// See: https://pbr-book.org/3ed-2018/Reflection_Models/Specular_Reflection_and_Transmission#FresnelReflectance
+func fresnelDielectric(cosI, etaI, etaT float64) float64 {
+	cosI = math.Max(-1, math.Min(1, cosI))
+	if cosI < 0 {
+		etaI, etaT = etaT, etaI
+		cosI = -cosI
+	}
+
+	sinT := etaI / etaT * safeSqrt(1-cosI*cosI)
+
+	// Total internal reflection
+	if sinT >= 1 {
+		return 1
+	}
+	cosT := safeSqrt(1 - sinT*sinT)
+
+	parallel := (etaT*cosI - etaI*cosT) / (etaT*cosI + etaI*cosT)
+	perpendicular := (etaI*cosI - etaT*cosT) / (etaI*cosI + etaT*cosT)
+	return (parallel*parallel + perpendicular*perpendicular) / 2
+}
+
+// cosineSampleHemisphere returns a direction around n, with a density proportional to the cosine with n
+func cosineSampleHemisphere(n Vector3, u1, u2 float64) Vector3 {
+	r1 := 2. * M_PI * u1
+	r2s := math.Sqrt(u2)
+
+	// Create orthonormal coordinate frame (w,u,v)
+	w := n
+	u := NewVector3(1, 1, 1)
+	if math.Abs(w.X) > .1 {
+		u = NewVector3(0, 1, 0)
+	}
+	u = u.Cross(w).Normalize()
+	v := w.Cross(u).Normalize()
+
+	d1 := u.MulScalar(math.Cos(r1) * r2s)
+	d2 := v.MulScalar(math.Sin(r1) * r2s)
+	d3 := w.MulScalar(math.Sqrt(1 - u2))
+
+	return d1.Add(d2).Add(d3).Normalize()
+}
+
+// uniformSampleSphere returns a direction uniformly distributed over the unit sphere
+func uniformSampleSphere(u1, u2 float64) Vector3 {
+	z := 1 - 2*u1
+	r := safeSqrt(1 - z*z)
+	phi := 2 * math.Pi * u2
+	return NewVector3(r*math.Cos(phi), r*math.Sin(phi), z)
+}
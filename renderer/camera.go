@@ -1,5 +1,7 @@
 package renderer
 
+import "math"
+
 type Camera struct {
 	position      Vector3
 	direction     Vector3
@@ -13,3 +15,65 @@ func NewCamera(position, direction Vector3) Camera {
 		cameraToWorld: LookAt(position, position.Add(direction.Normalize())),
 	}
 }
+
+// screenScale returns the half extents of the image plane, at a distance of 1 from the camera
+func (c Camera) screenScale(options RenderingOptions) (float64, float64) {
+	aspectRatio := float64(options.Width) / float64(options.Height)
+	scale := math.Tan(0.5 * degToRad(options.Fov))
+	return scale * aspectRatio, scale
+}
+
+// rayDirection returns the world direction going through a raster position
+func (c Camera) rayDirection(rasterX, rasterY float64, options RenderingOptions) Vector3 {
+	scaleX, scaleY := c.screenScale(options)
+
+	// Normalized Device Coordinates ([0,1])
+	pixelNdcX := rasterX / float64(options.Width)
+	pixelNdcY := rasterY / float64(options.Height)
+
+	// Screen space ([-1,1])
+	pixelScreenX := 2*pixelNdcX - 1
+	pixelScreenY := 1 - 2*pixelNdcY
+
+	return c.cameraToWorld.MultDirection(NewVector3(pixelScreenX*scaleX, pixelScreenY*scaleY, -1)).Normalize()
+}
+
+// rasterPosition projects a world direction leaving the camera on the raster. It returns false outside of the image.
+func (c Camera) rasterPosition(direction Vector3, options RenderingOptions) (float64, float64, bool) {
+	local := c.cameraToWorld.InverseMultDirection(direction)
+	if local.Z >= 0 {
+		return 0, 0, false
+	}
+
+	scaleX, scaleY := c.screenScale(options)
+	pixelScreenX := local.X / -local.Z / scaleX
+	pixelScreenY := local.Y / -local.Z / scaleY
+
+	rasterX := (pixelScreenX + 1) / 2 * float64(options.Width)
+	rasterY := (1 - pixelScreenY) / 2 * float64(options.Height)
+	if rasterX < 0 || rasterX >= float64(options.Width) || rasterY < 0 || rasterY >= float64(options.Height) {
+		return 0, 0, false
+	}
+
+	return rasterX, rasterY, true
+}
+
+// importance returns the importance emitted by the camera along a direction, normalized over the whole image plane,
+// and the solid angle density with which rayDirection generates it
+// See: https://pbr-book.org/3ed-2018/Light_Transport_III_Bidirectional_Methods/The_Path-Space_Measurement_Equation#SamplingCameras
+func (c Camera) importance(direction Vector3, options RenderingOptions) (float64, float64) {
+	if _, _, ok := c.rasterPosition(direction, options); !ok {
+		return 0, 0
+	}
+
+	cos := direction.Dot(c.direction.Normalize())
+	if cos <= 0 {
+		return 0, 0
+	}
+
+	scaleX, scaleY := c.screenScale(options)
+	area := 4 * scaleX * scaleY
+	cos2 := cos * cos
+
+	return 1 / (area * cos2 * cos2), 1 / (area * cos2 * cos)
+}
@@ -0,0 +1,132 @@
+package renderer
+
+import (
+	"math"
+	"math/rand"
+)
+
+// DebugMode is what the DebugTracer shows of the first surface seen through each pixel
+type DebugMode uint
+
+const (
+	// DebugShadingNormals shows the shading normals, mapped from [-1, 1] to [0, 1]
+	DebugShadingNormals DebugMode = iota
+	// DebugGeometricNormals shows the geometric normals, mapped from [-1, 1] to [0, 1]
+	DebugGeometricNormals
+	// DebugDepth shows the distance to the camera, from black to white at MaxDistance
+	DebugDepth
+	// DebugAlbedo shows the color of the materials, or their emission for lights
+	DebugAlbedo
+	// DebugUV shows the surface coordinates in the red and green channels
+	DebugUV
+	// DebugObjectID shows each object of the scene with its own color
+	DebugObjectID
+	// DebugIntersections shows the number of intersection tests made for the camera ray, from blue to red at MaxTests
+	DebugIntersections
+)
+
+// DebugTracer shows what the renderer sees of the scene, to check how it is set up.
+// A single ray goes through the center of each pixel. Invisible medium boundaries are looked through.
+type DebugTracer struct {
+	Mode        DebugMode
+	MaxDistance float64 // 1000 when zero
+	MaxTests    int     // 100 when zero
+}
+
+func (r DebugTracer) Sample(x, y uint, camera Camera, scene Scene, options RenderingOptions, rnd *rand.Rand) Vector3 {
+	ray := NewRay(camera.position, camera.rayDirection(float64(x)+.5, float64(y)+.5, options))
+
+	if r.Mode == DebugIntersections {
+		maxTests := r.MaxTests
+		if maxTests <= 0 {
+			maxTests = 100
+		}
+		return debugColor(heatmap(float64(scene.intersectionTests(ray, HiddenFromCamera)) / float64(maxTests)))
+	}
+
+	hit, index := scene.firstSurface(ray, HiddenFromCamera)
+	if index == -1 {
+		return Vector3Zero
+	}
+
+	switch r.Mode {
+	case DebugShadingNormals:
+		normal := hit.ShadingNormal
+		if normal.IsZero() {
+			normal = hit.Normal
+		}
+		return debugColor(normal.Add(NewVector3(1, 1, 1)).MulScalar(.5))
+
+	case DebugGeometricNormals:
+		return debugColor(hit.Normal.Add(NewVector3(1, 1, 1)).MulScalar(.5))
+
+	case DebugDepth:
+		maxDistance := r.MaxDistance
+		if maxDistance <= 0 {
+			maxDistance = 1000
+		}
+		d := math.Min(1, camera.position.DistanceTo(hit.Position)/maxDistance)
+		return debugColor(NewVector3(d, d, d))
+
+	case DebugAlbedo:
+		material := scene.Objects[index].Material()
+		if material.Color.IsZero() {
+			return debugColor(material.EmissionColor)
+		}
+		return debugColor(material.Color)
+
+	case DebugUV:
+		return debugColor(NewVector3(hit.U, hit.V, 0))
+
+	case DebugObjectID:
+		return debugColor(idColor(index))
+	}
+
+	return Vector3Zero
+}
+
+// debugColor returns the linear value that the film displays as the given color, once gamma corrected
+func debugColor(c Vector3) Vector3 {
+	return NewVector3(math.Pow(clamp(c.X), 2.2), math.Pow(clamp(c.Y), 2.2), math.Pow(clamp(c.Z), 2.2))
+}
+
+// heatmap maps [0, 1] to a ramp going from blue to green to red
+func heatmap(x float64) Vector3 {
+	x = math.Max(0, math.Min(1, x))
+	if x < .5 {
+		return NewVector3(0, 2*x, 1-2*x)
+	}
+	return NewVector3(2*x-1, 2-2*x, 0)
+}
+
+// idColor returns a color distinct enough from the ones of the neighbouring indices
+func idColor(i int) Vector3 {
+	// Golden ratio hue spacing
+	hue := math.Mod(float64(i)*0.618033988749895, 1)
+	return hsvToRGB(hue, .65, .95)
+}
+
+func hsvToRGB(h, s, v float64) Vector3 {
+	h6 := h * 6
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h6, 2)-1))
+	m := v - c
+
+	var rgb Vector3
+	switch int(h6) % 6 {
+	case 0:
+		rgb = NewVector3(c, x, 0)
+	case 1:
+		rgb = NewVector3(x, c, 0)
+	case 2:
+		rgb = NewVector3(0, c, x)
+	case 3:
+		rgb = NewVector3(0, x, c)
+	case 4:
+		rgb = NewVector3(x, 0, c)
+	default:
+		rgb = NewVector3(c, 0, x)
+	}
+
+	return rgb.Add(NewVector3(m, m, m))
+}
@@ -0,0 +1,306 @@
+package renderer
+
+import "math"
+
+// emissiveShape is implemented by geometries that can be sampled as area lights
+type emissiveShape interface {
+	Geometry
+	Area() float64
+	Bounds() AABB
+	SampleDirection(from Vector3, u1, u2 float64) (Vector3, float64)
+	SamplePoint(u1, u2 float64) (Vector3, Vector3)
+	normalBounds() (directionCone, bool)
+}
+
+// emissiveGroup is implemented by geometries made of several shapes, each sampled as its own emitter
+type emissiveGroup interface {
+	Geometry
+	emissiveShapes() []emissiveShape
+}
+
+// lightSample is a direction towards an emitter along with what it carries
+type lightSample struct {
+	direction Vector3
+	distance  float64 // Distance to the sampled point, used for the shadow test
+	radiance  Vector3
+	pdf       float64 // Solid angle density
+	point     Vector3
+	normal    Vector3 // Zero for point lights
+}
+
+// emissionSample is a ray leaving an emitter, used to start light paths
+type emissionSample struct {
+	point     Vector3
+	normal    Vector3 // Zero for point lights
+	direction Vector3
+	radiance  Vector3
+	pdfPos    float64 // Area density of the point, 1 for point lights
+	pdfDir    float64 // Solid angle density of the direction
+}
+
+type emitter interface {
+	sample(from Vector3, u1, u2 float64) lightSample
+	sampleEmission(u1, u2, u3, u4 float64) emissionSample
+	power() float64
+	lightBounds() lightBounds
+}
+
+// areaEmitter is an emissive object of the scene
+type areaEmitter struct {
+	shape emissiveShape
+}
+
+func (e areaEmitter) sample(from Vector3, u1, u2 float64) lightSample {
+	direction, pdf := e.shape.SampleDirection(from, u1, u2)
+	if pdf == 0 {
+		return lightSample{}
+	}
+
+	hit := e.shape.Intersects(NewRay(from, direction))
+	if !hit.Valid {
+		return lightSample{}
+	}
+
+	return lightSample{
+		direction: direction,
+		distance:  hit.Distance,
+		radiance:  e.shape.Material().EmissionColor,
+		pdf:       pdf,
+		point:     hit.Position,
+		normal:    hit.Normal,
+	}
+}
+
+func (e areaEmitter) sampleEmission(u1, u2, u3, u4 float64) emissionSample {
+	point, normal := e.shape.SamplePoint(u1, u2)
+	pdfDir := 1.
+
+	// Two-sided emitters pick a side first
+	if _, twoSided := e.shape.normalBounds(); twoSided {
+		pdfDir = .5
+		if u3 < .5 {
+			normal = normal.MulScalar(-1)
+			u3 = 2 * u3
+		} else {
+			u3 = 2*u3 - 1
+		}
+	}
+
+	direction := cosineSampleHemisphere(normal, u3, u4)
+
+	return emissionSample{
+		point:     point,
+		normal:    normal,
+		direction: direction,
+		radiance:  e.shape.Material().EmissionColor,
+		pdfPos:    1 / e.shape.Area(),
+		pdfDir:    pdfDir * direction.Dot(normal) * M_1_PI,
+	}
+}
+
+func (e areaEmitter) power() float64 {
+	// Lambertian emitter: radiance * area * PI
+	return e.shape.Material().EmissionColor.Luminance() * e.shape.Area() * math.Pi
+}
+
+func (e areaEmitter) lightBounds() lightBounds {
+	cone, twoSided := e.shape.normalBounds()
+
+	return lightBounds{
+		bounds:   e.shape.Bounds(),
+		phi:      e.power(),
+		cone:     cone,
+		cosE:     math.Cos(math.Pi / 2),
+		twoSided: twoSided,
+	}
+}
+
+// pointEmitter is a point, spot or IES light of the scene
+type pointEmitter struct {
+	light Light
+}
+
+func (e pointEmitter) sample(from Vector3, u1, u2 float64) lightSample {
+	toLight := e.light.Position.Sub(from)
+	distanceSquare := toLight.LengthSquared()
+	if distanceSquare == 0 {
+		return lightSample{}
+	}
+
+	direction := toLight.Normalize()
+
+	// Delta distribution: the pdf is 1 and the inverse square falloff is folded into the radiance
+	return lightSample{
+		direction: direction,
+		distance:  math.Sqrt(distanceSquare),
+		radiance:  e.light.Intensity(direction.MulScalar(-1)).MulScalar(1 / distanceSquare),
+		pdf:       1,
+		point:     e.light.Position,
+	}
+}
+
+func (e pointEmitter) sampleEmission(u1, u2, u3, u4 float64) emissionSample {
+	direction := uniformSampleSphere(u1, u2)
+
+	return emissionSample{
+		point:     e.light.Position,
+		direction: direction,
+		radiance:  e.light.Intensity(direction),
+		pdfPos:    1,
+		pdfDir:    1 / (4 * math.Pi),
+	}
+}
+
+func (e pointEmitter) power() float64 {
+	return e.light.Power()
+}
+
+func (e pointEmitter) lightBounds() lightBounds {
+	bounds := lightBounds{
+		bounds: NewAABB(e.light.Position, e.light.Position),
+		phi:    e.power(),
+		cone:   entireSphereCone,
+		cosE:   math.Cos(math.Pi / 2),
+	}
+
+	if e.light.SpotAngle > 0 && e.light.Profile == nil {
+		cosTotal, cosFalloff := e.light.spotCosines()
+		bounds.cone = directionCone{w: e.light.nadir(), cosTheta: cosFalloff}
+		bounds.cosE = math.Cos(safeAcos(cosTotal) - safeAcos(cosFalloff))
+	}
+
+	return bounds
+}
+
+// emitsTwoSided tells whether an emissive geometry emits light on both sides of its surface
+func emitsTwoSided(g Geometry) bool {
+	switch shape := g.(type) {
+	case emissiveShape:
+		_, twoSided := shape.normalBounds()
+		return twoSided
+	case emissiveGroup:
+		for _, part := range shape.emissiveShapes() {
+			_, twoSided := part.normalBounds()
+			return twoSided
+		}
+	}
+	return false
+}
+
+// directionCone bounds a set of directions by an axis and the cosine of its half angle
+type directionCone struct {
+	w        Vector3
+	cosTheta float64
+}
+
+var entireSphereCone = directionCone{w: NewVector3(0, 0, 1), cosTheta: -1}
+
+// union returns a cone bounding both cones
+// See: https://pbr-book.org/4ed/Geometry_and_Transformations/Spherical_Geometry#BoundingDirections
+func (a directionCone) union(b directionCone) directionCone {
+	thetaA := safeAcos(a.cosTheta)
+	thetaB := safeAcos(b.cosTheta)
+	thetaD := safeAcos(a.w.Dot(b.w))
+
+	if math.Min(thetaD+thetaB, math.Pi) <= thetaA {
+		return a
+	}
+	if math.Min(thetaD+thetaA, math.Pi) <= thetaB {
+		return b
+	}
+
+	thetaO := (thetaA + thetaD + thetaB) / 2
+	if thetaO >= math.Pi {
+		return entireSphereCone
+	}
+
+	// Rotate a's axis towards b's axis so that it is centered on the new cone
+	thetaR := thetaO - thetaA
+	axis := a.w.Cross(b.w)
+	if axis.LengthSquared() == 0 {
+		return entireSphereCone
+	}
+
+	return directionCone{w: rotate(a.w, axis.Normalize(), thetaR), cosTheta: math.Cos(thetaO)}
+}
+
+// lightBounds summarizes a set of emitters for the light BVH
+// See: https://pbr-book.org/4ed/Light_Sources/Light_Sampling#BVHLightSampling
+type lightBounds struct {
+	bounds   AABB
+	phi      float64
+	cone     directionCone // Bounds the surface normals of the emitters
+	cosE     float64       // Cosine of the angle beyond the normals over which they emit
+	twoSided bool
+}
+
+func (l lightBounds) union(l2 lightBounds) lightBounds {
+	if l.phi == 0 {
+		return l2
+	}
+	if l2.phi == 0 {
+		return l
+	}
+
+	return lightBounds{
+		bounds:   l.bounds.Union(l2.bounds),
+		phi:      l.phi + l2.phi,
+		cone:     l.cone.union(l2.cone),
+		cosE:     math.Min(l.cosE, l2.cosE),
+		twoSided: l.twoSided || l2.twoSided,
+	}
+}
+
+// importance estimates how much the emitters contribute to a point with the given normal
+func (l lightBounds) importance(p, n Vector3) float64 {
+	center := l.bounds.Center()
+	d2 := p.Sub(center).LengthSquared()
+	d2 = math.Max(d2, l.bounds.Diagonal().Length()/2)
+
+	// cos(max(0, a - b)) and sin(max(0, a - b))
+	cosSubClamped := func(sinA, cosA, sinB, cosB float64) float64 {
+		if cosA > cosB {
+			return 1
+		}
+		return cosA*cosB + sinA*sinB
+	}
+	sinSubClamped := func(sinA, cosA, sinB, cosB float64) float64 {
+		if cosA > cosB {
+			return 0
+		}
+		return sinA*cosB - cosA*sinB
+	}
+
+	wi := p.Sub(center).Normalize()
+	cosW := l.cone.w.Dot(wi)
+	if l.twoSided {
+		cosW = math.Abs(cosW)
+	}
+	sinW := safeSqrt(1 - cosW*cosW)
+
+	// Angle subtended by the bounds as seen from p
+	cosB := -1.
+	boundsCenter, boundsRadius := l.bounds.BoundingSphere()
+	if dist2 := p.Sub(boundsCenter).LengthSquared(); dist2 > boundsRadius*boundsRadius {
+		cosB = safeSqrt(1 - boundsRadius*boundsRadius/dist2)
+	}
+	sinB := safeSqrt(1 - cosB*cosB)
+
+	sinO := safeSqrt(1 - l.cone.cosTheta*l.cone.cosTheta)
+	cosX := cosSubClamped(sinW, cosW, sinO, l.cone.cosTheta)
+	sinX := sinSubClamped(sinW, cosW, sinO, l.cone.cosTheta)
+	cosP := cosSubClamped(sinX, cosX, sinB, cosB)
+	if cosP <= l.cosE {
+		return 0
+	}
+
+	importance := l.phi * cosP / d2
+
+	if !n.IsZero() {
+		cosI := math.Abs(wi.Dot(n))
+		sinI := safeSqrt(1 - cosI*cosI)
+		importance *= cosSubClamped(sinI, cosI, sinB, cosB)
+	}
+
+	return math.Max(importance, 0)
+}
@@ -0,0 +1,309 @@
+package renderer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Film holds the linear radiance of the rendered image. The values computed for each pixel are averaged
+// over the passes of a progressive render. Besides, samplers can splat contributions anywhere on it,
+// as light tracing strategies do, which are averaged over the passes as well.
+// With a reconstruction filter, the samples added with AddSample make the image instead of the values of the pixels,
+// which are still used to estimate the noise. For the median of means, the samples are dealt into groups in turn,
+// each one reconstructed apart, and each pixel takes the value of the group with the median luminance.
+type Film struct {
+	width   uint
+	height  uint
+	pixels  []Vector3 // Sum of the values computed for each pixel
+	counts  []int     // Number of values summed for each pixel
+	squares []float64 // Sum of the squared luminances of the values of each pixel
+	passes  int       // Passes completed
+
+	splatsLock sync.Mutex
+	splats     []Vector3
+
+	samples []int // Samples taken for each pixel, by the samplers counting them
+
+	filter   *filter
+	groups   int
+	filtered []Vector3 // Sum of the weighted samples around each pixel, the images of the groups one after the other
+	weights  []float64 // Sum of their weights
+	next     int       // Group of the next sample
+
+	// A film of a tile covers the tile and the pixels around it the filter reaches. Its samples are merged
+	// into the whole film in the order of the tiles, while its splats and sample counts go there directly.
+	whole  *Film
+	x0, y0 uint
+}
+
+func NewFilm(width, height uint) *Film {
+	return &Film{
+		width:   width,
+		height:  height,
+		pixels:  make([]Vector3, width*height),
+		counts:  make([]int, width*height),
+		squares: make([]float64, width*height),
+		splats:  make([]Vector3, width*height),
+		samples: make([]int, width*height),
+	}
+}
+
+// setFilter makes the film reconstruct the image from the samples added with AddSample, and filter the splats
+func (f *Film) setFilter(filter *filter, groups int) {
+	f.filter = filter
+	f.groups = groups
+	f.filtered = make([]Vector3, uint(groups)*f.width*f.height)
+	f.weights = make([]float64, uint(groups)*f.width*f.height)
+}
+
+// tileFilm returns a film gathering the samples of a tile, so that they are merged at once
+func (f *Film) tileFilm(t tile) *Film {
+	apron := int(math.Ceil(f.filter.radius))
+	x0 := maxInt(0, int(t.x0)-apron)
+	y0 := maxInt(0, int(t.y0)-apron)
+	x1 := minInt(int(f.width), int(t.x1)+apron)
+	y1 := minInt(int(f.height), int(t.y1)+apron)
+
+	tf := &Film{width: uint(x1 - x0), height: uint(y1 - y0), whole: f, x0: uint(x0), y0: uint(y0)}
+	tf.setFilter(f.filter, f.groups)
+	return tf
+}
+
+// merge adds the samples gathered by the film of a tile
+func (f *Film) merge(t *Film) {
+	f.splatsLock.Lock()
+	defer f.splatsLock.Unlock()
+
+	for g := uint(0); g < uint(f.groups); g++ {
+		for y := uint(0); y < t.height; y++ {
+			for x := uint(0); x < t.width; x++ {
+				i := g*t.width*t.height + y*t.width + x
+				j := g*f.width*f.height + (y+t.y0)*f.width + x + t.x0
+				f.filtered[j] = f.filtered[j].Add(t.filtered[i])
+				f.weights[j] += t.weights[i]
+			}
+		}
+	}
+}
+
+// AddSample weights a sample taken at a raster position onto the pixels around it. It is safe to call concurrently.
+func (f *Film) AddSample(x, y float64, c Vector3) {
+	if f.filter == nil {
+		return
+	}
+
+	f.splatsLock.Lock()
+	offset := uint(f.next) * f.width * f.height
+	f.next = (f.next + 1) % f.groups
+	f.filter.footprint(x-float64(f.x0), y-float64(f.y0), f.width, f.height, func(i uint, w float64) {
+		f.filtered[offset+i] = f.filtered[offset+i].Add(c.MulScalar(w))
+		f.weights[offset+i] += w
+	})
+	f.splatsLock.Unlock()
+}
+
+// filteredPixel reconstructs a pixel from the samples around it, if there are any
+func (f *Film) filteredPixel(i uint) (Vector3, bool) {
+	var means []Vector3
+	for g := uint(0); g < uint(f.groups); g++ {
+		j := g*f.width*f.height + i
+		if f.weights[j] > 0 {
+			means = append(means, f.filtered[j].MulScalar(1/f.weights[j]))
+		}
+	}
+
+	if len(means) == 0 {
+		return Vector3Zero, false
+	}
+	return medianLuminance(means), true
+}
+
+// Set stores the value computed for a pixel, replacing the previous ones
+func (f *Film) Set(x, y uint, c Vector3) {
+	f.pixels[y*f.width+x] = c
+	f.counts[y*f.width+x] = 1
+	f.squares[y*f.width+x] = c.Luminance() * c.Luminance()
+}
+
+// Add stores the value computed for a pixel during a pass, averaged with those of the previous passes
+func (f *Film) Add(x, y uint, c Vector3) {
+	f.pixels[y*f.width+x] = f.pixels[y*f.width+x].Add(c)
+	f.counts[y*f.width+x]++
+	f.squares[y*f.width+x] += c.Luminance() * c.Luminance()
+}
+
+// Error estimates the noise left in the image, from the differences between the values of each pixel.
+// It is the root mean square over the pixels of the standard error of their luminance, relatively to it.
+// There is no estimate before two values were computed for the pixels.
+func (f *Film) Error() (float64, bool) {
+	sum := 0.
+	pixels := 0
+
+	for i, count := range f.counts {
+		if count < 2 {
+			continue
+		}
+
+		n := float64(count)
+		mean := f.pixels[i].Luminance() / n
+		variance := math.Max(0, (f.squares[i]-n*mean*mean)/(n-1))
+		e := math.Sqrt(variance/n) / math.Max(mean, adaptiveMinLuminance)
+
+		sum += e * e
+		pixels++
+	}
+
+	if pixels == 0 {
+		return 0, false
+	}
+	return math.Sqrt(sum / float64(pixels)), true
+}
+
+// summary describes the samples per pixel and the error of the image, when they are known
+func (f *Film) summary() string {
+	s := ""
+
+	total := 0
+	for _, n := range f.samples {
+		total += n
+	}
+	if total > 0 {
+		s += fmt.Sprintf(", %.1f samples per pixel", float64(total)/float64(len(f.samples)))
+	}
+
+	if e, ok := f.Error(); ok {
+		s += fmt.Sprintf(", estimated error %.2f%%", 100*e)
+	}
+
+	return s
+}
+
+// EndPass tells the film a pass is over, once every pixel got its value
+func (f *Film) EndPass() {
+	f.splatsLock.Lock()
+	f.passes++
+	f.splatsLock.Unlock()
+}
+
+// Pixel returns the linear radiance of a pixel
+func (f *Film) Pixel(x, y uint) Vector3 {
+	f.splatsLock.Lock()
+	defer f.splatsLock.Unlock()
+	return f.pixel(y*f.width + x)
+}
+
+func (f *Film) pixel(i uint) Vector3 {
+	c := f.pixels[i]
+	if f.counts[i] > 1 {
+		c = c.MulScalar(1 / float64(f.counts[i]))
+	}
+	if f.filter != nil {
+		if filtered, ok := f.filteredPixel(i); ok {
+			c = filtered
+		}
+	}
+
+	// Splats of a pass in progress are divided by the passes completed before it
+	return c.Add(f.splats[i].MulScalar(1 / math.Max(1, float64(f.passes))))
+}
+
+// AddSamples counts samples taken for a pixel. Like Set, it is called by the worker sampling the pixel only.
+func (f *Film) AddSamples(x, y uint, count int) {
+	if f.whole != nil {
+		f.whole.AddSamples(x, y, count)
+		return
+	}
+	f.samples[y*f.width+x] += count
+}
+
+// Splat adds a contribution at a raster position. It is safe to call concurrently.
+// With a reconstruction filter, it is shared between the pixels around in proportion to their weights.
+func (f *Film) Splat(x, y float64, c Vector3) {
+	if f.whole != nil {
+		f.whole.Splat(x, y, c)
+		return
+	}
+	if x < 0 || y < 0 {
+		return
+	}
+
+	if f.filter != nil {
+		total := 0.
+		f.filter.footprint(x, y, f.width, f.height, func(_ uint, w float64) {
+			total += w
+		})
+
+		if total != 0 {
+			f.splatsLock.Lock()
+			f.filter.footprint(x, y, f.width, f.height, func(i uint, w float64) {
+				f.splats[i] = f.splats[i].Add(c.MulScalar(w / total))
+			})
+			f.splatsLock.Unlock()
+			return
+		}
+	}
+
+	px := uint(x)
+	py := uint(y)
+	if px >= f.width || py >= f.height {
+		return
+	}
+
+	f.splatsLock.Lock()
+	f.splats[py*f.width+px] = f.splats[py*f.width+px].Add(c)
+	f.splatsLock.Unlock()
+}
+
+// Image converts the film to 8 bits colors with a gamma correction
+func (f *Film) Image() *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, int(f.width), int(f.height)))
+
+	f.splatsLock.Lock()
+	defer f.splatsLock.Unlock()
+
+	for y := uint(0); y < f.height; y++ {
+		for x := uint(0); x < f.width; x++ {
+			c := f.pixel(y*f.width + x)
+			m.Set(int(x), int(y), color.RGBA{
+				uint8(gammaCorrection(c.X)),
+				uint8(gammaCorrection(c.Y)),
+				uint8(gammaCorrection(c.Z)),
+				255,
+			})
+		}
+	}
+
+	return m
+}
+
+func gammaCorrection(x float64) float64 {
+	// Gamma correction of 2.2
+	return math.Pow(clamp(x), 1./2.2)*255. + .5
+}
+
+// SamplesImage shows the samples taken for each pixel, from blue for the fewest to red for the most
+func (f *Film) SamplesImage() *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, int(f.width), int(f.height)))
+
+	least, most := math.MaxInt, 0
+	for _, n := range f.samples {
+		least = minInt(least, n)
+		most = maxInt(most, n)
+	}
+
+	for y := uint(0); y < f.height; y++ {
+		for x := uint(0); x < f.width; x++ {
+			t := 0.
+			if most > least {
+				t = float64(f.samples[y*f.width+x]-least) / float64(most-least)
+			}
+			c := heatmap(t)
+			m.Set(int(x), int(y), color.RGBA{uint8(c.X*255 + .5), uint8(c.Y*255 + .5), uint8(c.Z*255 + .5), 255})
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,110 @@
+package renderer
+
+import "math"
+
+// Filter selects the reconstruction filter with which the samples of the camera paths are weighted onto the
+// pixels around them, and with which the contributions of the light paths are splatted.
+// The image is then the weighted mean of the samples around each pixel, smoother than one averaged per pixel.
+// See: https://pbr-book.org/3ed-2018/Sampling_and_Reconstruction/Image_Reconstruction
+type Filter uint
+
+const (
+	// FilterNone averages the samples of each pixel, placed on it by Stratification, and splats onto a single pixel
+	FilterNone Filter = iota
+	// FilterBox weights all the samples within the radius evenly, 0.5 pixel by default
+	FilterBox
+	// FilterTriangle weights the samples less as they get further, 1 pixel by default
+	FilterTriangle
+	// FilterGaussian is a truncated Gaussian, 1.5 pixel by default
+	FilterGaussian
+	// FilterMitchell is the Mitchell-Netravali filter with B = C = 1/3, sharper, 2 pixels by default
+	FilterMitchell
+	// FilterLanczos is a sinc windowed by a wider sinc, the sharpest but ringing around edges, 3 pixels by default
+	FilterLanczos
+)
+
+var defaultFilterRadius = map[Filter]float64{
+	FilterBox:      .5,
+	FilterTriangle: 1,
+	FilterGaussian: 1.5,
+	FilterMitchell: 2,
+	FilterLanczos:  3,
+}
+
+const gaussianFilterAlpha = 2
+
+type filter struct {
+	kind   Filter
+	radius float64
+}
+
+// filter returns the reconstruction filter of the options, nil for FilterNone
+func (o RenderingOptions) filter() *filter {
+	if o.Filter == FilterNone {
+		return nil
+	}
+
+	radius := o.FilterRadius
+	if radius <= 0 {
+		radius = defaultFilterRadius[o.Filter]
+	}
+	return &filter{kind: o.Filter, radius: radius}
+}
+
+// eval returns the weight of a sample at an offset from the center of a pixel
+func (f *filter) eval(dx, dy float64) float64 {
+	return f.eval1D(dx) * f.eval1D(dy)
+}
+
+func (f *filter) eval1D(x float64) float64 {
+	x = math.Abs(x)
+	if x >= f.radius {
+		return 0
+	}
+
+	switch f.kind {
+	case FilterTriangle:
+		return f.radius - x
+	case FilterGaussian:
+		return math.Max(0, math.Exp(-gaussianFilterAlpha*x*x)-math.Exp(-gaussianFilterAlpha*f.radius*f.radius))
+	case FilterMitchell:
+		return mitchell(2 * x / f.radius)
+	case FilterLanczos:
+		return sinc(x) * sinc(x/f.radius)
+	}
+	return 1
+}
+
+// footprint calls fn for every pixel of a width x height image whose center is within the radius of a raster position
+func (f *filter) footprint(x, y float64, width, height uint, fn func(i uint, w float64)) {
+	minX := math.Max(0, math.Ceil(x-.5-f.radius))
+	maxX := math.Min(float64(width)-1, math.Floor(x-.5+f.radius))
+	minY := math.Max(0, math.Ceil(y-.5-f.radius))
+	maxY := math.Min(float64(height)-1, math.Floor(y-.5+f.radius))
+
+	for py := minY; py <= maxY; py++ {
+		for px := minX; px <= maxX; px++ {
+			if w := f.eval(px+.5-x, py+.5-y); w != 0 {
+				fn(uint(py)*width+uint(px), w)
+			}
+		}
+	}
+}
+
+// mitchell is the Mitchell-Netravali cubic over [0, 2], with B = C = 1/3
+func mitchell(x float64) float64 {
+	const b = 1. / 3.
+	const c = 1. / 3.
+
+	if x > 1 {
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+	return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+}
+
+func sinc(x float64) float64 {
+	if math.Abs(x) < 1e-5 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
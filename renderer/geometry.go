@@ -3,5 +3,6 @@ package renderer
 type Geometry interface {
 	Position() Vector3
 	Material() Material
+	Visibility() Visibility
 	Intersects(ray Ray) Hit
 }
@@ -0,0 +1,233 @@
+package renderer
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Path guiding learns, over the passes of a render, where the indirect light reaching each region of the scene
+// comes from, and sends the diffuse bounces of the path tracer there instead of only following the cosine.
+// Space is split by a binary tree refined where many paths go through, each leaf holding a histogram of the
+// incoming radiance over the directions. The histograms filled during a pass are used for sampling during the next.
+// See: https://tom94.net/data/publications/mueller17practical/mueller17practical.pdf
+
+const (
+	guideThetaBins = 16
+	guidePhiBins   = 32
+	guideBins      = guideThetaBins * guidePhiBins
+
+	defaultGuidingPasses       = 4
+	defaultGuidingBSDFFraction = .5
+
+	guideSplitThreshold = 4000 // Samples a leaf receives during a pass before it is split
+	guideMinSamples     = 64   // Samples a leaf needs before its histogram is trusted for sampling
+	guideMaxDepth       = 24
+)
+
+type guideState struct {
+	pass     int
+	sampling *guideTree // Learned during the previous passes, nil for the first one
+	training *guideTree // Filled during the current pass
+	pixels   []Vector3  // Sum of the estimates of each pixel over the passes
+}
+
+type guideTree struct {
+	nodes []guideNode
+}
+
+type guideNode struct {
+	bounds   AABB
+	axis     int
+	children [2]int // Zero for leaves, the root never being a child
+	radiance *guideHistogram
+}
+
+// guideHistogram is a histogram of the incoming radiance over the sphere of directions. The bins are equal area:
+// they are laid out regularly over the cosine of the polar angle and the azimuth.
+type guideHistogram struct {
+	lock    sync.Mutex
+	bins    [guideBins]float64
+	total   float64
+	samples int
+	cdf     []float64 // Built once the histogram is only read
+}
+
+// newGuideTree returns a tree made of a single leaf covering the given bounds
+func newGuideTree(bounds AABB) *guideTree {
+	return &guideTree{nodes: []guideNode{{bounds: bounds, axis: bounds.LargestAxis(), radiance: &guideHistogram{}}}}
+}
+
+func (t *guideTree) leaf(p Vector3) *guideNode {
+	node := &t.nodes[0]
+	for node.children[0] != 0 {
+		if p.Axis(node.axis) < node.bounds.Center().Axis(node.axis) {
+			node = &t.nodes[node.children[0]]
+		} else {
+			node = &t.nodes[node.children[1]]
+		}
+	}
+	return node
+}
+
+// refined returns a tree with the same structure, where the leaves which received many samples are split,
+// and with empty histograms ready to be filled
+func (t *guideTree) refined() *guideTree {
+	refined := &guideTree{nodes: make([]guideNode, 0, len(t.nodes))}
+	refined.copy(t, 0, 0)
+	return refined
+}
+
+func (t *guideTree) copy(from *guideTree, index int, depth int) int {
+	node := from.nodes[index]
+	i := len(t.nodes)
+	t.nodes = append(t.nodes, guideNode{bounds: node.bounds, axis: node.axis})
+
+	if node.children[0] != 0 {
+		left := t.copy(from, node.children[0], depth+1)
+		right := t.copy(from, node.children[1], depth+1)
+		t.nodes[i].children = [2]int{left, right}
+		return i
+	}
+
+	t.split(i, node.radiance.samples, depth)
+	return i
+}
+
+// split subdivides a leaf until each new leaf is expected to receive fewer samples than the threshold
+func (t *guideTree) split(i int, samples int, depth int) {
+	if samples <= guideSplitThreshold || depth >= guideMaxDepth {
+		t.nodes[i].radiance = &guideHistogram{}
+		return
+	}
+
+	bounds := t.nodes[i].bounds
+	axis := t.nodes[i].axis
+	center := bounds.Center()
+
+	leftBounds, rightBounds := bounds, bounds
+	switch axis {
+	case 0:
+		leftBounds.Max.X, rightBounds.Min.X = center.X, center.X
+	case 1:
+		leftBounds.Max.Y, rightBounds.Min.Y = center.Y, center.Y
+	default:
+		leftBounds.Max.Z, rightBounds.Min.Z = center.Z, center.Z
+	}
+
+	left := len(t.nodes)
+	t.nodes = append(t.nodes, guideNode{bounds: leftBounds, axis: (axis + 1) % 3})
+	right := len(t.nodes)
+	t.nodes = append(t.nodes, guideNode{bounds: rightBounds, axis: (axis + 1) % 3})
+	t.nodes[i].children = [2]int{left, right}
+
+	t.split(left, samples/2, depth+1)
+	t.split(right, samples/2, depth+1)
+}
+
+// freeze prepares the histograms of the tree for sampling
+func (t *guideTree) freeze() {
+	for i := range t.nodes {
+		if h := t.nodes[i].radiance; h != nil && h.samples >= guideMinSamples && h.total > 0 {
+			h.cdf = make([]float64, guideBins)
+			sum := 0.
+			for j, v := range h.bins {
+				sum += v
+				h.cdf[j] = sum / h.total
+			}
+		}
+	}
+}
+
+func guideBin(d Vector3) int {
+	theta := int((d.Y + 1) / 2 * guideThetaBins)
+	phi := int((math.Atan2(d.Z, d.X) + math.Pi) / (2 * math.Pi) * guidePhiBins)
+	return clampInt(theta, 0, guideThetaBins-1)*guidePhiBins + clampInt(phi, 0, guidePhiBins-1)
+}
+
+func clampInt(x, min, max int) int {
+	if x < min {
+		return min
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
+
+func (h *guideHistogram) record(d Vector3, value float64) {
+	bin := guideBin(d)
+	h.lock.Lock()
+	h.bins[bin] += value
+	h.total += value
+	h.samples++
+	h.lock.Unlock()
+}
+
+// pdf returns the density of the learned distribution over the solid angle
+func (h *guideHistogram) pdf(d Vector3) float64 {
+	return h.bins[guideBin(d)] / h.total * guideBins / (4 * math.Pi)
+}
+
+func (h *guideHistogram) sample(u1, u2, u3 float64) Vector3 {
+	bin := 0
+	for bin < guideBins-1 && h.cdf[bin] <= u1 {
+		bin++
+	}
+
+	cos := -1 + 2*(float64(bin/guidePhiBins)+u2)/guideThetaBins
+	phi := 2*math.Pi*(float64(bin%guidePhiBins)+u3)/guidePhiBins - math.Pi
+	sin := safeSqrt(1 - cos*cos)
+
+	return NewVector3(sin*math.Cos(phi), cos, sin*math.Sin(phi))
+}
+
+// newGuideState starts with a single leaf around what the camera sees, the region most paths go through
+func newGuideState(camera Camera, scene Scene, options RenderingOptions) *guideState {
+	bounds := scene.visibleBounds(camera, options)
+
+	return &guideState{
+		training: newGuideTree(bounds),
+		pixels:   make([]Vector3, options.Width*options.Height),
+	}
+}
+
+// sampleDiffuse picks the direction of a diffuse bounce, either by cosine sampling or from what was learned,
+// and returns it with the weight of the bounce and the density it was sampled with
+func (s *guideState) sampleDiffuse(p, normal, cosineDirection, color Vector3, fraction float64, rnd *rand.Rand) (Vector3, Vector3, float64) {
+	if s == nil || s.sampling == nil {
+		return cosineDirection, color, cosineDirection.Dot(normal) * M_1_PI
+	}
+
+	h := s.sampling.leaf(p).radiance
+	if h.cdf == nil {
+		return cosineDirection, color, cosineDirection.Dot(normal) * M_1_PI
+	}
+
+	d := cosineDirection
+	if rnd.Float64() >= fraction {
+		d = h.sample(rnd.Float64(), rnd.Float64(), rnd.Float64())
+	}
+
+	cos := d.Dot(normal)
+	if cos <= 0 {
+		return d, Vector3Zero, 0
+	}
+
+	// One sample of the mixture of both strategies, weighted by the density of the mixture
+	pdf := fraction*cos*M_1_PI + (1-fraction)*h.pdf(d)
+	return d, color.MulScalar(cos * M_1_PI / pdf), pdf
+}
+
+// record adds the radiance arriving at p from direction d, found with the given density, to what is learned
+func (s *guideState) record(p, d Vector3, radiance Vector3, pdf float64) {
+	if s == nil || pdf <= 0 {
+		return
+	}
+
+	l := radiance.Luminance()
+	if math.IsNaN(l) || math.IsInf(l, 0) {
+		return
+	}
+	s.training.leaf(p).radiance.record(d, l/pdf)
+}
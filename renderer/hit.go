@@ -5,6 +5,10 @@ type Hit struct {
 	Distance float64
 	Position Vector3
 	Normal   Vector3
+
+	// Optional surface details, not used by every sampler
+	ShadingNormal Vector3 // Interpolated normal, zero when the surface only has its geometric normal
+	U, V          float64 // Surface coordinates
 }
 
 // NoHit represents a lack of collision between a ray and a geometry
@@ -0,0 +1,226 @@
+package renderer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IESProfile is the candela distribution of a luminaire, read from an IES LM-63 file.
+// Only type C photometry is supported: vertical angles are measured from the nadir
+// (0 points straight down, 180 straight up) and horizontal angles around it.
+// See: https://docs.agi32.com/PhotometricToolbox/Content/Open_Tool/iesna_lm-63_format.htm
+type IESProfile struct {
+	verticalAngles   []float64 // Degrees, increasing
+	horizontalAngles []float64 // Degrees, increasing
+	candela          [][]float64
+	flux             float64 // Integral of the distribution over the sphere, in lumens
+}
+
+// LoadIESProfile reads an IES LM-63 file
+func LoadIESProfile(path string) (*IESProfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseIESProfile(f)
+}
+
+// ParseIESProfile reads the content of an IES LM-63 file (1995 or 2002 revision)
+func ParseIESProfile(r io.Reader) (*IESProfile, error) {
+	scanner := bufio.NewScanner(r)
+
+	// Skip the header and keywords until the TILT line
+	tilt := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(strings.ToUpper(line), "TILT=") {
+			tilt = strings.ToUpper(strings.TrimSpace(line[len("TILT="):]))
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if tilt == "" {
+		return nil, fmt.Errorf("ies: missing TILT line")
+	}
+
+	// The rest of the file is a list of numbers spread over an arbitrary number of lines
+	var values []float64
+	for scanner.Scan() {
+		for _, field := range strings.FieldsFunc(scanner.Text(), func(c rune) bool {
+			return c == ' ' || c == '\t' || c == ','
+		}) {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ies: invalid number %q", field)
+			}
+			values = append(values, v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	next := func(n int) ([]float64, error) {
+		if len(values) < n {
+			return nil, fmt.Errorf("ies: unexpected end of file")
+		}
+		v := values[:n]
+		values = values[n:]
+		return v, nil
+	}
+
+	// Lamp to luminaire geometry, then angle/multiplier pairs. Tilt is ignored.
+	if tilt == "INCLUDE" {
+		header, err := next(2)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := next(2 * int(header[1])); err != nil {
+			return nil, err
+		}
+	}
+
+	// #lamps, lumens per lamp, multiplier, #vertical, #horizontal, photometric type, units, width, length, height
+	// followed by ballast factor, ballast-lamp photometric factor and input watts
+	header, err := next(13)
+	if err != nil {
+		return nil, err
+	}
+
+	multiplier := header[2] * header[10] * header[11]
+	verticalCount := int(header[3])
+	horizontalCount := int(header[4])
+	photometricType := int(header[5])
+
+	if photometricType != 1 {
+		return nil, fmt.Errorf("ies: unsupported photometric type %d, only type C is supported", photometricType)
+	}
+	if verticalCount < 1 || horizontalCount < 1 {
+		return nil, fmt.Errorf("ies: invalid angle counts %d x %d", verticalCount, horizontalCount)
+	}
+
+	p := &IESProfile{}
+
+	if p.verticalAngles, err = next(verticalCount); err != nil {
+		return nil, err
+	}
+	if p.horizontalAngles, err = next(horizontalCount); err != nil {
+		return nil, err
+	}
+	if !sort.Float64sAreSorted(p.verticalAngles) || !sort.Float64sAreSorted(p.horizontalAngles) {
+		return nil, fmt.Errorf("ies: angles must be increasing")
+	}
+
+	p.candela = make([][]float64, horizontalCount)
+	for h := range p.candela {
+		row, err := next(verticalCount)
+		if err != nil {
+			return nil, err
+		}
+		p.candela[h] = make([]float64, verticalCount)
+		for v := range row {
+			p.candela[h][v] = row[v] * multiplier
+		}
+	}
+
+	p.flux = p.integrate()
+
+	return p, nil
+}
+
+// Candela returns the luminous intensity for a vertical angle from the nadir and
+// a horizontal angle, both in degrees
+func (p *IESProfile) Candela(vertical, horizontal float64) float64 {
+	// Outside the measured vertical range no light is emitted
+	if vertical < p.verticalAngles[0] || vertical > p.verticalAngles[len(p.verticalAngles)-1] {
+		return 0
+	}
+
+	h0, h1, th := bracket(p.horizontalAngles, p.foldHorizontal(horizontal))
+	v0, v1, tv := bracket(p.verticalAngles, vertical)
+
+	c0 := p.candela[h0][v0]*(1-tv) + p.candela[h0][v1]*tv
+	c1 := p.candela[h1][v0]*(1-tv) + p.candela[h1][v1]*tv
+
+	return c0*(1-th) + c1*th
+}
+
+// Flux returns the luminous flux of the luminaire in lumens
+func (p *IESProfile) Flux() float64 {
+	return p.flux
+}
+
+// foldHorizontal maps a horizontal angle into the measured range, according to the luminaire symmetry
+func (p *IESProfile) foldHorizontal(angle float64) float64 {
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+
+	switch last := p.horizontalAngles[len(p.horizontalAngles)-1]; {
+	case len(p.horizontalAngles) == 1 || last == 0:
+		// Rotationally symmetric
+		return p.horizontalAngles[0]
+	case last == 90:
+		// Symmetric in each quadrant
+		angle = math.Mod(angle, 180)
+		if angle > 90 {
+			angle = 180 - angle
+		}
+	case last == 180:
+		// Bilaterally symmetric about the 0-180 plane
+		if angle > 180 {
+			angle = 360 - angle
+		}
+	}
+
+	return angle
+}
+
+// integrate computes the flux by integrating the intensity over the sphere with the midpoint rule
+func (p *IESProfile) integrate() float64 {
+	const thetaSteps = 180
+	const phiSteps = 360
+
+	dTheta := math.Pi / thetaSteps
+	dPhi := 2 * math.Pi / phiSteps
+
+	sum := 0.
+	for i := 0; i < thetaSteps; i++ {
+		theta := (float64(i) + .5) * dTheta
+		for j := 0; j < phiSteps; j++ {
+			phi := (float64(j) + .5) * dPhi
+			sum += p.Candela(radToDeg(theta), radToDeg(phi)) * math.Sin(theta)
+		}
+	}
+
+	return sum * dTheta * dPhi
+}
+
+// bracket returns the indices surrounding x in a sorted slice and the interpolation factor between them
+func bracket(values []float64, x float64) (int, int, float64) {
+	n := len(values)
+	if n == 1 || x <= values[0] {
+		return 0, 0, 0
+	}
+	if x >= values[n-1] {
+		return n - 1, n - 1, 0
+	}
+
+	i := sort.SearchFloat64s(values, x)
+	if values[i] == x {
+		return i, i, 0
+	}
+
+	return i - 1, i, (x - values[i-1]) / (values[i] - values[i-1])
+}
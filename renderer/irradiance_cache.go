@@ -0,0 +1,240 @@
+package renderer
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// The irradiance cache computes the indirect irradiance of diffuse surfaces at sparse points only, by gathering
+// the radiance over their hemisphere, and interpolates it elsewhere using its gradients.
+// The indirect light is smooth in diffuse interiors, so a few thousand points are enough to render them.
+// See: https://www.graphics.cornell.edu/~bjw/IrradianceGradients.pdf
+// and: https://cgg.mff.cuni.cz/~jaroslav/papers/2008-irradiance_caching_class/
+
+const (
+	defaultIrradianceCacheError = .3
+	defaultIrradianceSamples    = 256
+
+	// Radii of the records, relatively to the size of what the camera sees
+	irradianceMinRadius = .005
+	irradianceMaxRadius = .1
+)
+
+type irradianceCache struct {
+	lock      sync.RWMutex
+	records   []irradianceRecord
+	cells     map[[3]int][]int // Records whose validity domain overlaps each cell
+	cellSize  float64
+	minRadius float64
+	maxRadius float64
+	maxError  float64
+	filling   bool // Whether the cache is being filled, before the image itself is rendered
+}
+
+type irradianceRecord struct {
+	point       Vector3
+	normal      Vector3
+	irradiance  Vector3
+	radius      float64    // Harmonic mean distance to the surfaces around
+	rotation    [3]Vector3 // Gradient of each channel of the irradiance when the normal rotates
+	translation [3]Vector3 // Gradient of each channel of the irradiance when the point moves
+}
+
+func newIrradianceCache(camera Camera, scene Scene, options RenderingOptions, maxError float64) *irradianceCache {
+	size := scene.visibleBounds(camera, options).Diagonal().Length()
+	maxRadius := size * irradianceMaxRadius
+
+	return &irradianceCache{
+		cells:     map[[3]int][]int{},
+		cellSize:  math.Max(maxRadius*maxError, EPS),
+		minRadius: size * irradianceMinRadius,
+		maxRadius: maxRadius,
+		maxError:  maxError,
+		filling:   true,
+	}
+}
+
+func (c *irradianceCache) cell(p Vector3) [3]int {
+	return [3]int{
+		int(math.Floor(p.X / c.cellSize)),
+		int(math.Floor(p.Y / c.cellSize)),
+		int(math.Floor(p.Z / c.cellSize)),
+	}
+}
+
+// lookup interpolates the irradiance at p from the records around, if there are any close enough
+func (c *irradianceCache) lookup(p, n Vector3) (Vector3, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	sum := Vector3Zero
+	weights := 0.
+
+	for _, i := range c.cells[c.cell(p)] {
+		record := &c.records[i]
+
+		// Records in front of the point do not see the same light
+		d := p.Sub(record.point)
+		if d.Dot(n.Add(record.normal).MulScalar(.5)) < -.05*record.radius {
+			continue
+		}
+
+		w := d.Length()/record.radius + safeSqrt(1-n.Dot(record.normal))
+		if w*c.maxError >= 1 {
+			continue
+		}
+		w = 1 / math.Max(w, 1e-6)
+
+		// First order extrapolation of the record to the point
+		rotation := record.normal.Cross(n)
+		e := record.irradiance.Add(NewVector3(
+			rotation.Dot(record.rotation[0])+d.Dot(record.translation[0]),
+			rotation.Dot(record.rotation[1])+d.Dot(record.translation[1]),
+			rotation.Dot(record.rotation[2])+d.Dot(record.translation[2]),
+		))
+
+		sum = sum.Add(e.Max(Vector3Zero).MulScalar(w))
+		weights += w
+	}
+
+	if weights == 0 {
+		return Vector3Zero, false
+	}
+	return sum.MulScalar(1 / weights), true
+}
+
+func (c *irradianceCache) add(record irradianceRecord) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	i := len(c.records)
+	c.records = append(c.records, record)
+
+	// The record is used up to a distance of radius * maxError
+	reach := NewVector3(1, 1, 1).MulScalar(record.radius * c.maxError)
+	min := c.cell(record.point.Sub(reach))
+	max := c.cell(record.point.Add(reach))
+	for x := min[0]; x <= max[0]; x++ {
+		for y := min[1]; y <= max[1]; y++ {
+			for z := min[2]; z <= max[2]; z++ {
+				key := [3]int{x, y, z}
+				c.cells[key] = append(c.cells[key], i)
+			}
+		}
+	}
+}
+
+// gather computes a new record at p by tracing stratified cosine-distributed rays with the given path tracer,
+// and estimates the gradients from the differences between neighbouring strata
+func (c *irradianceCache) gather(r PathTracer, p, n Vector3, samples int, scene Scene, options RenderingOptions, path pathState, rnd *rand.Rand, medium Medium) irradianceRecord {
+	m := int(math.Max(1, math.Round(math.Sqrt(float64(samples)/math.Pi))))
+	k := int(math.Max(1, float64(samples/m)))
+
+	u, v := coordinateSystem(n)
+	direction := func(theta, phi float64) Vector3 {
+		return u.MulScalar(math.Cos(phi)).Add(v.MulScalar(math.Sin(phi))).MulScalar(math.Sin(theta)).Add(n.MulScalar(math.Cos(theta)))
+	}
+
+	radiance := make([]Vector3, m*k)
+	distance := make([]float64, m*k)
+	inverseDistances := 0.
+
+	for j := 0; j < m; j++ {
+		for i := 0; i < k; i++ {
+			theta := math.Asin(math.Sqrt((float64(j) + rnd.Float64()) / float64(m)))
+			phi := 2 * math.Pi * (float64(i) + rnd.Float64()) / float64(k)
+			ray := NewRay(p, direction(theta, phi))
+
+			hit, _ := scene.intersect(ray, HiddenFromIndirect)
+			distance[j*k+i] = math.Inf(1)
+			if hit.Valid {
+				distance[j*k+i] = hit.Distance
+				inverseDistances += 1 / hit.Distance
+			}
+
+			radiance[j*k+i] = r.radiance(ray, scene, options, path, rnd, 0, HiddenFromIndirect, medium)
+		}
+	}
+
+	record := irradianceRecord{point: p, normal: n, radius: c.maxRadius}
+	if inverseDistances > 0 {
+		record.radius = float64(m*k) / inverseDistances
+	}
+	record.radius = math.Max(c.minRadius, math.Min(c.maxRadius, record.radius))
+
+	sum := Vector3Zero
+	for _, l := range radiance {
+		sum = sum.Add(l)
+	}
+	record.irradiance = sum.MulScalar(math.Pi / float64(m*k))
+
+	minDistance := func(a, b int) float64 {
+		return math.Max(math.Min(distance[a], distance[b]), c.minRadius)
+	}
+
+	for i := 0; i < k; i++ {
+		phi := 2 * math.Pi * (float64(i) + .5) / float64(k)
+		phiMinus := 2 * math.Pi * float64(i) / float64(k)
+		uk := direction(math.Pi/2, phi)
+		vk := direction(math.Pi/2, phi+math.Pi/2)
+		vkMinus := direction(math.Pi/2, phiMinus+math.Pi/2)
+
+		rotation := Vector3Zero
+		radial := Vector3Zero
+		tangential := Vector3Zero
+
+		for j := 0; j < m; j++ {
+			theta := math.Asin(math.Sqrt((float64(j) + .5) / float64(m)))
+			rotation = rotation.Add(radiance[j*k+i].MulScalar(math.Tan(theta)))
+
+			// Change across the boundary with the previous ring
+			if j > 0 {
+				sinMinus := math.Sqrt(float64(j) / float64(m))
+				cos2 := 1 - sinMinus*sinMinus
+				difference := radiance[j*k+i].Sub(radiance[(j-1)*k+i])
+				radial = radial.Add(difference.MulScalar(sinMinus * cos2 / minDistance(j*k+i, (j-1)*k+i)))
+			}
+
+			// Change across the boundary with the previous wedge
+			previous := (i + k - 1) % k
+			sinPlus := math.Sqrt(float64(j+1) / float64(m))
+			sinMinus := math.Sqrt(float64(j) / float64(m))
+			difference := radiance[j*k+i].Sub(radiance[j*k+previous])
+			tangential = tangential.Add(difference.MulScalar((sinPlus - sinMinus) / minDistance(j*k+i, j*k+previous)))
+		}
+
+		rotation = rotation.MulScalar(math.Pi / float64(m*k))
+		radial = radial.MulScalar(2 * math.Pi / float64(k))
+
+		for channel := 0; channel < 3; channel++ {
+			record.rotation[channel] = record.rotation[channel].Add(vk.MulScalar(rotation.Axis(channel)))
+			record.translation[channel] = record.translation[channel].
+				Add(uk.MulScalar(radial.Axis(channel))).
+				Add(vkMinus.MulScalar(tangential.Axis(channel)))
+		}
+	}
+
+	return record
+}
+
+// indirectIrradiance returns the cached indirect irradiance at p, gathering a new record when none is close enough
+func (r PathTracer) indirectIrradiance(p, n Vector3, scene Scene, options RenderingOptions, path pathState, rnd *rand.Rand, medium Medium) Vector3 {
+	if e, ok := r.cache.lookup(p, n); ok {
+		return e
+	}
+
+	samples := r.IrradianceSamples
+	if samples <= 0 {
+		samples = defaultIrradianceSamples
+	}
+
+	// The gathered paths are fully path traced
+	tracer := r
+	tracer.cache = nil
+
+	record := r.cache.gather(tracer, p, n, samples, scene, options, path, rnd, medium)
+	r.cache.add(record)
+
+	return record.irradiance
+}
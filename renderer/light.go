@@ -1,10 +1,112 @@
 package renderer
 
+import "math"
+
+// Light is a point light. Giving it a direction and a spot angle turns it into a spot light,
+// giving it a profile modulates its emission by a measured candela distribution.
 type Light struct {
 	Position      Vector3
-	EmissionColor Vector3
+	EmissionColor Vector3 // Radiant intensity, scaled by the profile and the spot cone when set
+
+	Direction        Vector3 // Spot axis, or nadir of the profile (defaults to -Y)
+	SpotAngle        float64 // Half angle of the cone in degrees, 0 for an omnidirectional light
+	SpotFalloffAngle float64 // Half angle in degrees at which the intensity starts decreasing
+	Profile          *IESProfile
 }
 
 func NewLight(position, emissionColor Vector3) Light {
 	return Light{Position: position, EmissionColor: emissionColor}
 }
+
+func NewSpotLight(position, direction, emissionColor Vector3, angle, falloffAngle float64) Light {
+	return Light{
+		Position:         position,
+		EmissionColor:    emissionColor,
+		Direction:        direction.Normalize(),
+		SpotAngle:        angle,
+		SpotFalloffAngle: falloffAngle,
+	}
+}
+
+// NewIESLight creates a light emitting as described by the profile, in candela. The color is
+// normalized so that only its chromaticity matters, and scale multiplies the measured intensities.
+func NewIESLight(position, direction, color Vector3, profile *IESProfile, scale float64) Light {
+	if luminance := color.Luminance(); luminance > 0 {
+		color = color.MulScalar(scale / luminance)
+	}
+
+	return Light{
+		Position:      position,
+		EmissionColor: color,
+		Direction:     direction.Normalize(),
+		Profile:       profile,
+	}
+}
+
+// Intensity returns the radiant intensity emitted towards the given normalized direction
+func (l Light) Intensity(w Vector3) Vector3 {
+	intensity := l.EmissionColor
+
+	if l.Profile != nil {
+		vertical, horizontal := l.profileAngles(w)
+		intensity = intensity.MulScalar(l.Profile.Candela(vertical, horizontal))
+	}
+
+	if l.SpotAngle > 0 {
+		intensity = intensity.MulScalar(l.spotFalloff(w))
+	}
+
+	return intensity
+}
+
+// Power returns the total power emitted by the light, as the luminance of its intensity integrated over the sphere
+func (l Light) Power() float64 {
+	luminance := l.EmissionColor.Luminance()
+
+	if l.Profile != nil {
+		return luminance * l.Profile.Flux()
+	}
+
+	if l.SpotAngle > 0 {
+		// Average of the smoothstep falloff over the cone
+		cosTotal, cosFalloff := l.spotCosines()
+		return luminance * 2 * math.Pi * (1 - .5*(cosTotal+cosFalloff))
+	}
+
+	return luminance * 4 * math.Pi
+}
+
+func (l Light) nadir() Vector3 {
+	if l.Direction.IsZero() {
+		return NewVector3(0, -1, 0)
+	}
+	return l.Direction
+}
+
+func (l Light) spotCosines() (float64, float64) {
+	falloff := math.Min(l.SpotFalloffAngle, l.SpotAngle)
+	return math.Cos(degToRad(l.SpotAngle)), math.Cos(degToRad(falloff))
+}
+
+func (l Light) spotFalloff(w Vector3) float64 {
+	cosTotal, cosFalloff := l.spotCosines()
+	return smoothStep(w.Dot(l.nadir()), cosTotal, cosFalloff)
+}
+
+// profileAngles converts a direction into IES type C angles, in degrees
+func (l Light) profileAngles(w Vector3) (float64, float64) {
+	nadir := l.nadir()
+
+	// Horizontal angles are measured from an arbitrary but fixed reference direction
+	reference := NewVector3(1, 0, 0)
+	if math.Abs(nadir.X) > .9 {
+		reference = NewVector3(0, 0, 1)
+	}
+	u := reference.Sub(nadir.MulScalar(reference.Dot(nadir))).Normalize()
+	v := nadir.Cross(u)
+
+	vertical := radToDeg(safeAcos(w.Dot(nadir)))
+	horizontal := radToDeg(math.Atan2(w.Dot(v), w.Dot(u)))
+
+	return vertical, horizontal
+}
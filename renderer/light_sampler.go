@@ -0,0 +1,279 @@
+package renderer
+
+import "sort"
+
+// LightSampling selects how the path tracer picks emitters for direct lighting.
+// The bidirectional path tracer picks them by power whatever the strategy, its MIS weights needing the same
+// density for the lights it connects to as for those starting light paths.
+type LightSampling uint
+
+const (
+	// SampleAllLights samples every emitter at every diffuse hit
+	SampleAllLights LightSampling = iota
+	// SampleLightsByPower picks a single emitter proportionally to its emitted power
+	SampleLightsByPower
+	// SampleLightsBVH picks a single emitter by walking a light BVH, accounting for distance and orientation
+	SampleLightsBVH
+)
+
+// lightSet holds the emitters of a scene and the structure used to choose among them
+type lightSet struct {
+	emitters   []emitter
+	ids        []LightID
+	strategy   LightSampling
+	alias      *aliasTable
+	bvh        *lightBVH
+	totalPower float64
+}
+
+func newLightSet(scene Scene, strategy LightSampling) *lightSet {
+	set := &lightSet{strategy: strategy}
+
+	for i, obj := range scene.Objects {
+		if obj.Material().EmissionColor == Vector3Zero {
+			continue
+		}
+		switch shape := obj.(type) {
+		case emissiveShape:
+			set.emitters = append(set.emitters, areaEmitter{shape: shape})
+			set.ids = append(set.ids, EmissiveObject(i))
+		case emissiveGroup:
+			// Every part becomes its own emitter so that they are weighted by their power
+			for _, part := range shape.emissiveShapes() {
+				set.emitters = append(set.emitters, areaEmitter{shape: part})
+				set.ids = append(set.ids, EmissiveObject(i))
+			}
+		}
+	}
+
+	for i, light := range scene.Lights {
+		set.emitters = append(set.emitters, pointEmitter{light: light})
+		set.ids = append(set.ids, SceneLight(i))
+	}
+
+	// Power based selection is also used to start light paths, whatever the strategy
+	weights := make([]float64, len(set.emitters))
+	for i, e := range set.emitters {
+		weights[i] = e.power()
+		set.totalPower += weights[i]
+	}
+	set.alias = newAliasTable(weights)
+
+	if strategy == SampleLightsBVH {
+		set.bvh = newLightBVH(set.emitters)
+	}
+
+	return set
+}
+
+// choose picks the index of one emitter for a point p with normal n, along with the probability of
+// having picked it. It returns -1 when no emitter can contribute.
+func (s *lightSet) choose(p, n Vector3, u float64) (int, float64) {
+	if len(s.emitters) == 0 {
+		return -1, 0
+	}
+
+	switch s.strategy {
+	case SampleLightsByPower:
+		i, pmf := s.alias.sample(u)
+		if pmf == 0 {
+			return -1, 0
+		}
+		return i, pmf
+	case SampleLightsBVH:
+		return s.bvh.sample(p, n, u)
+	}
+
+	return -1, 0
+}
+
+// aliasTable samples an index in constant time proportionally to its weight
+// See: https://www.keithschwarz.com/darts-dice-coins/ (Vose's alias method)
+type aliasTable struct {
+	prob  []float64
+	alias []int
+	pmf   []float64
+}
+
+func newAliasTable(weights []float64) *aliasTable {
+	n := len(weights)
+	t := &aliasTable{
+		prob:  make([]float64, n),
+		alias: make([]int, n),
+		pmf:   make([]float64, n),
+	}
+
+	sum := 0.
+	for _, w := range weights {
+		sum += w
+	}
+	if sum == 0 {
+		return t
+	}
+
+	scaled := make([]float64, n)
+	var small, large []int
+	for i, w := range weights {
+		t.pmf[i] = w / sum
+		scaled[i] = t.pmf[i] * float64(n)
+		if scaled[i] < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+
+	for len(small) > 0 && len(large) > 0 {
+		s := small[len(small)-1]
+		small = small[:len(small)-1]
+		l := large[len(large)-1]
+		large = large[:len(large)-1]
+
+		t.prob[s] = scaled[s]
+		t.alias[s] = l
+
+		scaled[l] = scaled[l] + scaled[s] - 1
+		if scaled[l] < 1 {
+			small = append(small, l)
+		} else {
+			large = append(large, l)
+		}
+	}
+
+	// Leftovers are only due to floating point errors
+	for _, i := range large {
+		t.prob[i] = 1
+	}
+	for _, i := range small {
+		t.prob[i] = 1
+	}
+
+	return t
+}
+
+func (t *aliasTable) sample(u float64) (int, float64) {
+	n := len(t.prob)
+	if n == 0 {
+		return -1, 0
+	}
+
+	scaled := u * float64(n)
+	i := int(scaled)
+	if i >= n {
+		i = n - 1
+	}
+
+	if scaled-float64(i) < t.prob[i] {
+		return i, t.pmf[i]
+	}
+	return t.alias[i], t.pmf[t.alias[i]]
+}
+
+// lightBVH is a bounding volume hierarchy over emitters, traversed stochastically
+// according to the importance of each node for the shaded point.
+type lightBVH struct {
+	nodes []lightBVHNode
+}
+
+type lightBVHNode struct {
+	bounds lightBounds
+	leaf   bool
+	index  int // Emitter index for leaves, second child index for interior nodes
+}
+
+func newLightBVH(emitters []emitter) *lightBVH {
+	bvh := &lightBVH{}
+
+	var indices []int
+	for i, e := range emitters {
+		if e.power() > 0 {
+			indices = append(indices, i)
+		}
+	}
+
+	if len(indices) > 0 {
+		bvh.build(emitters, indices)
+	}
+
+	return bvh
+}
+
+// build appends the subtree for the given emitters in depth-first order; the first child directly follows its parent
+func (bvh *lightBVH) build(emitters []emitter, indices []int) int {
+	nodeIndex := len(bvh.nodes)
+
+	if len(indices) == 1 {
+		bvh.nodes = append(bvh.nodes, lightBVHNode{
+			bounds: emitters[indices[0]].lightBounds(),
+			leaf:   true,
+			index:  indices[0],
+		})
+		return nodeIndex
+	}
+
+	// Split at the middle along the largest axis of the centroids
+	centroids := EmptyAABB
+	for _, i := range indices {
+		centroids = centroids.Extend(emitters[i].lightBounds().bounds.Center())
+	}
+	axis := centroids.LargestAxis()
+
+	sort.Slice(indices, func(a, b int) bool {
+		return emitters[indices[a]].lightBounds().bounds.Center().Axis(axis) <
+			emitters[indices[b]].lightBounds().bounds.Center().Axis(axis)
+	})
+	mid := len(indices) / 2
+
+	bvh.nodes = append(bvh.nodes, lightBVHNode{})
+	first := bvh.build(emitters, indices[:mid])
+	second := bvh.build(emitters, indices[mid:])
+
+	bvh.nodes[nodeIndex] = lightBVHNode{
+		bounds: bvh.nodes[first].bounds.union(bvh.nodes[second].bounds),
+		index:  second,
+	}
+
+	return nodeIndex
+}
+
+// sample returns an emitter index and the probability of having chosen it, or -1
+func (bvh *lightBVH) sample(p, n Vector3, u float64) (int, float64) {
+	if len(bvh.nodes) == 0 {
+		return -1, 0
+	}
+
+	pmf := 1.
+	nodeIndex := 0
+	for {
+		node := bvh.nodes[nodeIndex]
+		if node.leaf {
+			if node.bounds.importance(p, n) <= 0 {
+				return -1, 0
+			}
+			return node.index, pmf
+		}
+
+		first := nodeIndex + 1
+		second := node.index
+		ci0 := bvh.nodes[first].bounds.importance(p, n)
+		ci1 := bvh.nodes[second].bounds.importance(p, n)
+		if ci0 == 0 && ci1 == 0 {
+			return -1, 0
+		}
+
+		// Pick a child and remap u so it can be reused further down
+		p0 := ci0 / (ci0 + ci1)
+		if u < p0 {
+			nodeIndex = first
+			pmf *= p0
+			u = u / p0
+		} else {
+			nodeIndex = second
+			pmf *= 1 - p0
+			u = (u - p0) / (1 - p0)
+		}
+		if u >= 1 {
+			u = 0.99999999
+		}
+	}
+}
@@ -1,12 +1,36 @@
 package renderer
 
+// Index of refraction of transparent materials without one
+const defaultIOR = 1.5
+
 type Material struct {
 	Color         Vector3
 	EmissionColor Vector3
 	Reflectivity  float64
 	Transparency  float64
+	IOR           float64 // Index of refraction of transparent materials, 1.5 when zero
+
+	// Interior is the medium filling the geometry, which must be closed. A material with an interior
+	// but no color, reflectivity, transparency nor emission only delimits the medium and is invisible.
+	Interior Medium
 }
 
 func NewMaterial(color Vector3, reflectivity float64, transparency float64, emissionColor Vector3) Material {
 	return Material{Color: color, EmissionColor: emissionColor, Reflectivity: reflectivity, Transparency: transparency}
 }
+
+// NewMediumBoundary returns an invisible material delimiting a medium
+func NewMediumBoundary(interior Medium) Material {
+	return Material{Interior: interior}
+}
+
+func (m Material) ior() float64 {
+	if m.IOR <= 0 {
+		return defaultIOR
+	}
+	return m.IOR
+}
+
+func (m Material) isMediumBoundary() bool {
+	return m.Interior != nil && m.Color.IsZero() && m.EmissionColor.IsZero() && m.Reflectivity == 0 && m.Transparency == 0
+}
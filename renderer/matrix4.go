@@ -57,3 +57,11 @@ func (matrix *Matrix4) MultDirection(v Vector3) Vector3 {
 		v.X*matrix.m[0][1]+v.Y*matrix.m[1][1]+v.Z*matrix.m[2][1],
 		v.X*matrix.m[0][2]+v.Y*matrix.m[1][2]+v.Z*matrix.m[2][2])
 }
+
+// InverseMultDirection transforms a direction by the inverse of the matrix, which must be a rotation
+func (matrix *Matrix4) InverseMultDirection(v Vector3) Vector3 {
+	return NewVector3(
+		v.X*matrix.m[0][0]+v.Y*matrix.m[0][1]+v.Z*matrix.m[0][2],
+		v.X*matrix.m[1][0]+v.Y*matrix.m[1][1]+v.Z*matrix.m[1][2],
+		v.X*matrix.m[2][0]+v.Y*matrix.m[2][1]+v.Z*matrix.m[2][2])
+}
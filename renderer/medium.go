@@ -0,0 +1,162 @@
+package renderer
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Medium is a participating medium, such as fog, smoke or murky water. It fills the whole scene when set on it,
+// or the interior of closed geometries when set on their material. Media cannot be nested: leaving a geometry
+// always goes back to the medium of the scene.
+type Medium interface {
+	// sampleDistance samples where a ray travelling through the medium scatters, before reaching tMax.
+	// It returns the distance, whether the ray scatters before tMax, the weight of the sample,
+	// and the radiance emitted by the medium along the way, already weighted.
+	sampleDistance(ray Ray, tMax float64, rnd *rand.Rand) (float64, bool, Vector3, Vector3)
+
+	// transmittance returns the fraction of light going through the medium along the ray, up to distance
+	transmittance(ray Ray, distance float64, rnd *rand.Rand) Vector3
+
+	// phase returns the density of scattering from -wo into wi, both pointing away from the scattering point
+	phase(wo, wi Vector3) float64
+
+	// samplePhase picks a scattering direction according to the phase function
+	samplePhase(wo Vector3, u1, u2 float64) Vector3
+}
+
+// HomogeneousMedium has the same density everywhere. Coefficients are given per scene unit.
+type HomogeneousMedium struct {
+	Absorption Vector3
+	Scattering Vector3
+	G          float64 // Asymmetry of the Henyey-Greenstein phase function, from -1 (backward) to 1 (forward)
+}
+
+func NewHomogeneousMedium(absorption, scattering Vector3, g float64) HomogeneousMedium {
+	return HomogeneousMedium{Absorption: absorption, Scattering: scattering, G: g}
+}
+
+func (m HomogeneousMedium) extinction() Vector3 {
+	return m.Absorption.Add(m.Scattering)
+}
+
+// sampleDistance samples the free flight distance in one of the channels, picked uniformly,
+// and weights it by the average density of the three channels
+// See: https://pbr-book.org/3ed-2018/Light_Transport_II_Volume_Rendering/Sampling_Volume_Scattering#HomogeneousMedium
+func (m HomogeneousMedium) sampleDistance(ray Ray, tMax float64, rnd *rand.Rand) (float64, bool, Vector3, Vector3) {
+	sigmaT := m.extinction()
+
+	channel := int(math.Min(rnd.Float64()*3, 2))
+	t := -math.Log(1-rnd.Float64()) / sigmaT.Axis(channel)
+	scattered := t < tMax
+	t = math.Min(t, tMax)
+
+	tr := m.transmittance(ray, t, rnd)
+
+	if scattered {
+		pdf := average(sigmaT.Mul(tr))
+		if pdf == 0 {
+			return t, false, Vector3Zero, Vector3Zero
+		}
+		return t, true, tr.Mul(m.Scattering).MulScalar(1 / pdf), Vector3Zero
+	}
+
+	pdf := average(tr)
+	if pdf == 0 {
+		return t, false, Vector3Zero, Vector3Zero
+	}
+	return t, false, tr.MulScalar(1 / pdf), Vector3Zero
+}
+
+func (m HomogeneousMedium) transmittance(ray Ray, distance float64, rnd *rand.Rand) Vector3 {
+	sigmaT := m.extinction()
+	return NewVector3(
+		beerLambert(sigmaT.X, distance),
+		beerLambert(sigmaT.Y, distance),
+		beerLambert(sigmaT.Z, distance),
+	)
+}
+
+func (m HomogeneousMedium) phase(wo, wi Vector3) float64 {
+	return henyeyGreenstein(wo.Dot(wi), m.G)
+}
+
+func (m HomogeneousMedium) samplePhase(wo Vector3, u1, u2 float64) Vector3 {
+	return sampleHenyeyGreenstein(wo, m.G, u1, u2)
+}
+
+// beerLambert returns the transmittance over a distance for an extinction coefficient
+func beerLambert(sigmaT, distance float64) float64 {
+	if sigmaT == 0 {
+		return 1
+	}
+	return math.Exp(-sigmaT * distance)
+}
+
+// henyeyGreenstein returns the phase function for the cosine between wo and wi. Both point away from
+// the scattering point, so a positive g favors wi close to -wo.
+func henyeyGreenstein(cos, g float64) float64 {
+	denom := 1 + g*g + 2*g*cos
+	return (1 - g*g) / (4 * math.Pi * denom * math.Sqrt(denom))
+}
+
+// sampleHenyeyGreenstein returns a direction distributed according to the phase function
+// See: https://pbr-book.org/3ed-2018/Light_Transport_II_Volume_Rendering/Sampling_Volume_Scattering#SamplingPhaseFunctions
+func sampleHenyeyGreenstein(wo Vector3, g, u1, u2 float64) Vector3 {
+	var cos float64
+	if math.Abs(g) < 1e-3 {
+		cos = 1 - 2*u1
+	} else {
+		sqrTerm := (1 - g*g) / (1 + g - 2*g*u1)
+		cos = -(1 + g*g - sqrTerm*sqrTerm) / (2 * g)
+	}
+
+	sin := safeSqrt(1 - cos*cos)
+	phi := 2 * math.Pi * u2
+	u, v := coordinateSystem(wo)
+
+	return u.MulScalar(sin * math.Cos(phi)).
+		Add(v.MulScalar(sin * math.Sin(phi))).
+		Add(wo.MulScalar(cos)).
+		Normalize()
+}
+
+// mediumAfter returns the medium a ray is in after crossing the surface of an object in the given direction
+func (s Scene) mediumAfter(material Material, normal, direction Vector3) Medium {
+	if direction.Dot(normal) < 0 {
+		return material.Interior
+	}
+	return s.Medium
+}
+
+// transmittance returns the fraction of light going from p to the point at distance in the given direction,
+// zero if an object stands in between. Boundaries of media are crossed, their media attenuating the light.
+func (s Scene) transmittance(p, direction Vector3, distance float64, medium Medium, rnd *rand.Rand) Vector3 {
+	tr := NewVector3(1, 1, 1)
+	target := p.Add(direction.MulScalar(distance))
+	origin := p
+
+	for {
+		ray := NewRay(origin, direction)
+		hit, index := s.intersect(ray, HiddenFromShadows)
+
+		if !hit.Valid || hit.Distance >= distance*(1-1e-6) {
+			if medium != nil {
+				tr = tr.Mul(medium.transmittance(ray, distance, rnd))
+			}
+			return tr
+		}
+
+		material := s.Objects[index].Material()
+		if !material.isMediumBoundary() {
+			return Vector3Zero
+		}
+
+		if medium != nil {
+			tr = tr.Mul(medium.transmittance(ray, hit.Distance, rnd))
+		}
+
+		medium = s.mediumAfter(material, hit.Normal, direction)
+		origin = offsetRayOrigin(hit.Position, hit.Normal, direction)
+		distance = target.DistanceTo(origin)
+	}
+}
@@ -0,0 +1,173 @@
+package renderer
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Mesh is a set of triangles sharing a material, intersected through a bounding volume hierarchy.
+// An emissive mesh is sampled as an area light, each triangle weighted by its emitted power.
+type Mesh struct {
+	triangles  []Triangle
+	nodes      []meshNode
+	bounds     AABB
+	material   Material
+	visibility Visibility
+}
+
+type meshNode struct {
+	bounds AABB
+	start  int // First triangle of a leaf
+	count  int // Number of triangles of a leaf, 0 for interior nodes
+	second int // Second child of an interior node, the first one directly follows its parent
+}
+
+const (
+	maxTrianglesPerLeaf = 4
+	meshStackSize       = 64
+)
+
+// CreateMesh builds a mesh from a list of vertices and faces indexing them.
+// It fails if a face indexes a vertex which does not exist.
+func CreateMesh(vertices []Vector3, faces [][3]int, material Material) (Mesh, error) {
+	m := Mesh{bounds: EmptyAABB, material: material}
+
+	for i, f := range faces {
+		for _, v := range f {
+			if v < 0 || v >= len(vertices) {
+				return Mesh{}, fmt.Errorf("mesh: face %d indexes vertex %d, out of %d vertices", i, v, len(vertices))
+			}
+		}
+
+		t := CreateTriangle(vertices[f[0]], vertices[f[1]], vertices[f[2]], material)
+		if t.area == 0 {
+			continue
+		}
+
+		m.triangles = append(m.triangles, t)
+		m.bounds = m.bounds.Union(t.Bounds())
+	}
+
+	if len(m.triangles) > 0 {
+		m.build(0, len(m.triangles))
+	}
+
+	return m, nil
+}
+
+// build appends the subtree for triangles[start:end] and returns its index
+func (m *Mesh) build(start, end int) int {
+	nodeIndex := len(m.nodes)
+
+	bounds := EmptyAABB
+	centroids := EmptyAABB
+	for _, t := range m.triangles[start:end] {
+		bounds = bounds.Union(t.Bounds())
+		centroids = centroids.Extend(t.Position())
+	}
+
+	if end-start <= maxTrianglesPerLeaf {
+		m.nodes = append(m.nodes, meshNode{bounds: bounds, start: start, count: end - start})
+		return nodeIndex
+	}
+
+	// Split at the median along the largest axis of the centroids
+	axis := centroids.LargestAxis()
+	triangles := m.triangles[start:end]
+	sort.Slice(triangles, func(a, b int) bool {
+		return triangles[a].Position().Axis(axis) < triangles[b].Position().Axis(axis)
+	})
+	mid := (start + end) / 2
+
+	m.nodes = append(m.nodes, meshNode{bounds: bounds})
+	m.build(start, mid)
+	m.nodes[nodeIndex].second = m.build(mid, end)
+
+	return nodeIndex
+}
+
+func (m Mesh) Position() Vector3 {
+	return m.bounds.Center()
+}
+
+func (m Mesh) Material() Material {
+	return m.material
+}
+
+func (m Mesh) Visibility() Visibility {
+	return m.visibility
+}
+
+// WithVisibility returns a copy of the mesh hidden from the given kinds of rays
+func (m Mesh) WithVisibility(visibility Visibility) Mesh {
+	m.visibility = visibility
+	return m
+}
+
+func (m Mesh) Bounds() AABB {
+	return m.bounds
+}
+
+func (m Mesh) Triangles() []Triangle {
+	return m.triangles
+}
+
+func (m Mesh) Intersects(r Ray) Hit {
+	hit, _ := m.traverse(r)
+	return hit
+}
+
+// intersectionTests returns the number of boxes and triangles tested to intersect the ray
+func (m Mesh) intersectionTests(r Ray) int {
+	_, tests := m.traverse(r)
+	return tests
+}
+
+func (m Mesh) traverse(r Ray) (Hit, int) {
+	if len(m.nodes) == 0 {
+		return NoHit, 0
+	}
+
+	// The tree is split at medians, so it is never deeper than the stack
+	nearest := NoHit
+	var stack [meshStackSize]int
+	size := 1
+	tests := 0
+
+	for size > 0 {
+		size--
+		nodeIndex := stack[size]
+		node := m.nodes[nodeIndex]
+
+		tests++
+		tMin, _, ok := node.bounds.IntersectRay(r)
+		if !ok || (nearest.Valid && tMin > nearest.Distance) {
+			continue
+		}
+
+		if node.count > 0 {
+			for _, t := range m.triangles[node.start : node.start+node.count] {
+				tests++
+				hit := t.Intersects(r)
+				if hit.Valid && (!nearest.Valid || hit.Distance < nearest.Distance) {
+					nearest = hit
+				}
+			}
+			continue
+		}
+
+		stack[size] = node.second
+		stack[size+1] = nodeIndex + 1
+		size += 2
+	}
+
+	return nearest, tests
+}
+
+func (m Mesh) emissiveShapes() []emissiveShape {
+	shapes := make([]emissiveShape, len(m.triangles))
+	for i, t := range m.triangles {
+		shapes[i] = t
+	}
+	return shapes
+}
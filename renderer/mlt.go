@@ -0,0 +1,243 @@
+package renderer
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// MLT is a primary sample space Metropolis light transport sampler built on the path tracer.
+// The random numbers a path tracer sample consumes are mutated, either slightly around the current path
+// or entirely, and the resulting paths are accepted according to their luminance. Bright but hard to find
+// paths, such as light coming through a narrow gap, are then explored instead of being found once in a while.
+// Contributions are splatted onto the film, a bootstrap phase estimating the overall brightness of the image.
+// See: https://pbr-book.org/3ed-2018/Light_Transport_III_Bidirectional_Methods/Metropolis_Light_Transport
+type MLT struct {
+	MutationsPerPixel    int     // 100 when zero
+	BootstrapSamples     int     // 100000 when zero
+	Sigma                float64 // Standard deviation of small mutations, 0.01 when zero
+	LargeStepProbability float64 // Probability of replacing all the random numbers, 0.3 when zero
+
+	film  *Film
+	state *mltState
+}
+
+const (
+	defaultMutationsPerPixel    = 100
+	defaultBootstrapSamples     = 100000
+	defaultMutationSigma        = .01
+	defaultLargeStepProbability = .3
+)
+
+type mltState struct {
+	brightness float64     // Integral of the luminance over the primary sample space
+	bootstrap  *aliasTable // Bootstrap paths, according to their luminance
+	seed       int64       // Seed of the first bootstrap path
+}
+
+func (r MLT) WithFilm(film *Film) Sampler {
+	r.film = film
+	return r
+}
+
+func (r MLT) Passes() int {
+	return 1
+}
+
+func (r MLT) mutationsPerPixel() int {
+	if r.MutationsPerPixel <= 0 {
+		return defaultMutationsPerPixel
+	}
+	return r.MutationsPerPixel
+}
+
+func (r MLT) newPrimarySamples(seed int64) *primarySamples {
+	s := &primarySamples{
+		rng:                  rand.New(rand.NewSource(seed)),
+		sigma:                r.Sigma,
+		largeStepProbability: r.LargeStepProbability,
+		largeStep:            true,
+	}
+	if s.sigma <= 0 {
+		s.sigma = defaultMutationSigma
+	}
+	if s.largeStepProbability <= 0 {
+		s.largeStepProbability = defaultLargeStepProbability
+	}
+	return s
+}
+
+// BeginPass runs the bootstrap: independent paths estimate the brightness of the image and seed the chains
+func (r MLT) BeginPass(pass int, camera Camera, scene Scene, options RenderingOptions) Sampler {
+	count := r.BootstrapSamples
+	if count <= 0 {
+		count = defaultBootstrapSamples
+	}
+
+	state := &mltState{seed: newRandom(options.Seed, uint64(pass), 0, 0).Int63()}
+	weights := make([]float64, count)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func(thread int) {
+			defer wg.Done()
+
+			for j := thread; j < count; j += workers {
+				_, _, l := r.evaluate(r.newPrimarySamples(state.seed+int64(j)), camera, scene, options)
+				weights[j] = l.Luminance()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	sum := 0.
+	for _, w := range weights {
+		sum += w
+	}
+
+	state.brightness = sum / float64(count)
+	state.bootstrap = newAliasTable(weights)
+	r.state = state
+
+	return r
+}
+
+// evaluate traces the path defined by the primary samples and returns its raster position and radiance
+func (r MLT) evaluate(samples *primarySamples, camera Camera, scene Scene, options RenderingOptions) (float64, float64, Vector3) {
+	samples.index = 0
+	rnd := rand.New(samples)
+
+	x := rnd.Float64() * float64(options.Width)
+	y := rnd.Float64() * float64(options.Height)
+	ray := NewRay(camera.position, camera.rayDirection(x, y, options))
+
+	return x, y, PathTracer{}.radiance(ray, scene, options, cameraPath(), rnd, 1, HiddenFromCamera, scene.Medium)
+}
+
+// Sample runs a Markov chain started from a bootstrap path, which splats its contributions anywhere on the film.
+// The chains of all pixels together make as many mutations as there are pixels times MutationsPerPixel.
+func (r MLT) Sample(x, y uint, camera Camera, scene Scene, options RenderingOptions, rnd *rand.Rand) Vector3 {
+	if r.state == nil || r.film == nil || r.state.brightness == 0 {
+		return Vector3Zero
+	}
+
+	mutations := r.mutationsPerPixel()
+	weight := r.state.brightness * options.exposureScale() / float64(mutations)
+
+	i, _ := r.state.bootstrap.sample(rnd.Float64())
+	samples := r.newPrimarySamples(r.state.seed + int64(i))
+
+	currentX, currentY, current := r.evaluate(samples, camera, scene, options)
+	currentLuminance := current.Luminance()
+	if currentLuminance == 0 {
+		return Vector3Zero
+	}
+
+	// The bootstrap seed only replays the starting path, pixels starting from the same one must not mutate it alike
+	samples.rng = rand.New(rand.NewSource(rnd.Int63()))
+
+	for j := 0; j < mutations; j++ {
+		samples.startIteration()
+		proposedX, proposedY, proposed := r.evaluate(samples, camera, scene, options)
+		proposedLuminance := proposed.Luminance()
+
+		accept := math.Min(1, proposedLuminance/currentLuminance)
+
+		// Both states contribute according to their expected value
+		if accept > 0 {
+			r.film.Splat(proposedX, proposedY, proposed.MulScalar(accept*weight/proposedLuminance))
+		}
+		r.film.Splat(currentX, currentY, current.MulScalar((1-accept)*weight/currentLuminance))
+
+		if samples.rng.Float64() < accept {
+			currentX, currentY, current, currentLuminance = proposedX, proposedY, proposed, proposedLuminance
+			samples.accept()
+		} else {
+			samples.reject()
+		}
+	}
+
+	return Vector3Zero
+}
+
+// primarySamples is the vector of random numbers a path is built from. It is a random source handing out
+// its values in order, mutating them lazily the first time they are used in an iteration.
+// See: https://pbr-book.org/3ed-2018/Light_Transport_III_Bidirectional_Methods/Metropolis_Light_Transport#PrimarySampleSpaceMLT
+type primarySamples struct {
+	rng                  *rand.Rand
+	sigma                float64
+	largeStepProbability float64
+
+	samples       []primarySample
+	index         int
+	iteration     int
+	largeStep     bool
+	lastLargeStep int
+}
+
+type primarySample struct {
+	value          float64
+	modified       int // Last iteration the value was modified
+	valueBackup    float64
+	modifiedBackup int
+}
+
+func (s *primarySamples) startIteration() {
+	s.iteration++
+	s.largeStep = s.rng.Float64() < s.largeStepProbability
+}
+
+func (s *primarySamples) accept() {
+	if s.largeStep {
+		s.lastLargeStep = s.iteration
+	}
+}
+
+func (s *primarySamples) reject() {
+	for i := range s.samples {
+		if s.samples[i].modified == s.iteration {
+			s.samples[i].value = s.samples[i].valueBackup
+			s.samples[i].modified = s.samples[i].modifiedBackup
+		}
+	}
+	s.iteration--
+}
+
+func (s *primarySamples) next() float64 {
+	if s.index >= len(s.samples) {
+		s.samples = append(s.samples, primarySample{})
+	}
+
+	x := &s.samples[s.index]
+	s.index++
+
+	// Values not used since the last accepted large step are drawn again
+	if x.modified < s.lastLargeStep {
+		x.value = s.rng.Float64()
+		x.modified = s.lastLargeStep
+	}
+
+	x.valueBackup = x.value
+	x.modifiedBackup = x.modified
+
+	if s.largeStep {
+		x.value = s.rng.Float64()
+	} else {
+		// Small mutations missed while the value was not used are applied at once
+		n := float64(s.iteration - x.modified)
+		x.value += s.rng.NormFloat64() * s.sigma * math.Sqrt(n)
+		x.value -= math.Floor(x.value)
+	}
+	x.modified = s.iteration
+
+	return x.value
+}
+
+// Int63 makes the primary samples a rand.Source, so that rand.Rand.Float64 returns them unchanged
+func (s *primarySamples) Int63() int64 {
+	return int64(s.next() * (1 << 63))
+}
+
+func (s *primarySamples) Seed(int64) {}
@@ -5,104 +5,268 @@ import (
 	"math/rand"
 )
 
+// PathTracer traces paths from the camera, sampling the lights at each diffuse bounce.
+// With Guiding, the render is made of several passes, each one learning from the previous ones where the
+// indirect light comes from, to send more of the diffuse bounces there. The passes are averaged.
+// With IrradianceCache, the indirect light of the diffuse surfaces seen from the camera, directly or through
+// mirrors and glass, is interpolated from a cache filled during a first pass. It is much faster but biased,
+// and takes precedence over Guiding.
 type PathTracer struct {
+	Guiding             bool
+	GuidingPasses       int     // 4 when zero
+	GuidingBSDFFraction float64 // Fraction of the guided bounces still following the cosine, 0.5 when zero
+
+	IrradianceCache      bool
+	IrradianceCacheError float64 // Maximum interpolation error, the larger the sparser the cache, 0.3 when zero
+	IrradianceSamples    int     // Rays gathering the irradiance of a cached point, 256 when zero
+
+	guide *guideState
+	cache *irradianceCache
+	film  *Film
+}
+
+// WithFilm gives the path tracer the film, on which it counts the samples of each pixel
+// and weights them with the reconstruction filter
+func (r PathTracer) WithFilm(film *Film) Sampler {
+	r.film = film
+	return r
 }
 
 var defaultColor Vector3 = NewVector3(0, 0, 0)
 
 var M_PI float64 = math.Pi
 var M_1_PI float64 = 1. / M_PI
-var nbSamples int = 4
+
+func (r PathTracer) Passes() int {
+	if r.IrradianceCache {
+		return 2
+	}
+	if !r.Guiding {
+		return 0
+	}
+	if r.GuidingPasses <= 0 {
+		return defaultGuidingPasses
+	}
+	return r.GuidingPasses
+}
+
+// BeginPass samples with what was learned during the previous passes, and learns anew during this one.
+// With the irradiance cache, the first pass fills the cache and the second one renders the image.
+func (r PathTracer) BeginPass(pass int, camera Camera, scene Scene, options RenderingOptions) Sampler {
+	if r.IrradianceCache {
+		if r.cache == nil {
+			maxError := r.IrradianceCacheError
+			if maxError <= 0 {
+				maxError = defaultIrradianceCacheError
+			}
+			r.cache = newIrradianceCache(camera, scene, options, maxError)
+		} else {
+			r.cache.filling = false
+		}
+		return r
+	}
+
+	if !r.Guiding {
+		return r
+	}
+
+	if r.guide == nil {
+		r.guide = newGuideState(camera, scene, options)
+		return r
+	}
+
+	r.guide.training.freeze()
+	r.guide.sampling = r.guide.training
+	r.guide.training = r.guide.training.refined()
+	r.guide.pass = pass
+
+	return r
+}
+
+func (r PathTracer) guidingBSDFFraction() float64 {
+	if r.GuidingBSDFFraction <= 0 || r.GuidingBSDFFraction > 1 {
+		return defaultGuidingBSDFFraction
+	}
+	return r.GuidingBSDFFraction
+}
 
 func (r PathTracer) Sample(x, y uint, camera Camera, scene Scene, options RenderingOptions, rnd *rand.Rand) Vector3 {
-	// See: https://www.scratchapixel.com/lessons/3d-basic-rendering/ray-tracing-generating-camera-rays/generating-camera-rays
-	w := float64(options.Width)
-	h := float64(options.Height)
+	exposure := options.exposureScale()
 
-	cam := NewRay(camera.position, camera.direction)
+	// A single ray through the center of the pixel is enough to fill the cache, and gives a preview
+	if r.cache != nil && r.cache.filling {
+		ray := NewRay(camera.position, camera.rayDirection(float64(x)+.5, float64(y)+.5, options))
+		c := r.radiance(ray, scene, options, cameraPath(), rnd, 1, HiddenFromCamera, scene.Medium).MulScalar(exposure)
+		return NewVector3(clamp(c.X), clamp(c.Y), clamp(c.Z))
+	}
+
+	spp := options.samplesPerPixel()
+	strata := options.strataPerSide() * options.strataPerSide()
+	maxSamples := options.maxSamplesPerPixel()
 
-	aspectRatio := w / h
-	scale := math.Tan(0.5 * degToRad(options.Fov))
+	// Each pass goes on with the next samples of the sequences
+	first := options.pass * maxSamples
+	samples := options.newPixelSamples(x, y, spp, rnd)
+	rnd = rand.New(samples)
 
+	// With adaptive sampling, the pixel is sampled again until its error is low enough
+	var statistics sampleStatistics
 	pixelColor := NewVector3(0, 0, 0)
-	for sy := 0; sy < 2; sy++ {
-		for sx := 0; sx < 2; sx++ {
+	estimate := newPixelEstimate(options.estimatorGroups())
+	batches := 0
+
+	for statistics.count < maxSamples {
+		for stratum := 0; stratum < strata; stratum++ {
+			count := options.stratumSamples(stratum)
+			if count == 0 {
+				continue
+			}
+
 			acc := NewVector3(0, 0, 0)
-			for subSample := 0; subSample < nbSamples; subSample++ {
-				r1 := 2. * rand.Float64()
-				dx := ternaryFloat64(r1 < 1, math.Sqrt(r1)-1., 1.-math.Sqrt(2.-r1))
-
-				r2 := 2. * rand.Float64()
-				dy := ternaryFloat64(r2 < 1, math.Sqrt(r2)-1., 1.-math.Sqrt(2.-r2))
-
-				// Normalized Device Coordinates ([0,1])
-				// We add 0.5 because we want to pass through the center of the pixel, not the top left corner
-				pixelNdcX := ((dx+float64(sx))/2. + float64(x) + 0.5) / w
-				pixelNdcY := ((dy+float64(sy))/2. + float64(y) + 0.5) / h
-
-				// Screen space ([-1,1])
-				pixelScreenX := 2*pixelNdcX - 1
-				pixelScreenY := 1 - 2*pixelNdcY // We want the Y axis to go UP, not DOWN so we "inverse" it
-
-				// Camera space (Applying aspect ratio, scale and camera transform)
-				pixelCameraX := pixelScreenX * aspectRatio * scale
-				pixelCameraY := pixelScreenY * scale
-				pixelCameraSpace := camera.cameraToWorld.MultDirection(NewVector3(pixelCameraX, pixelCameraY, -1)).Normalize()
-
-				// Compute color for that pixel
-				radiance := r.radiance(NewRay(cam.Origin, pixelCameraSpace), scene, options, 0, rnd, 1)
-				acc = acc.Add(radiance.MulScalar(1. / float64(nbSamples)))
+			for i := 0; i < count; i++ {
+				samples.startSample(first)
+				first++
+
+				rasterX, rasterY := options.pixelSample(x, y, stratum, rnd)
+				ray := NewRay(camera.position, camera.rayDirection(rasterX, rasterY, options))
+
+				radiance := r.radiance(ray, scene, options, cameraPath(), rnd, 1, HiddenFromCamera, scene.Medium)
+				radiance = clampLuminance(radiance, options.ClampSamples).MulScalar(exposure)
+				acc = acc.Add(radiance.MulScalar(1 / float64(count)))
+				estimate.add(radiance)
+
+				// With clamped strata, the film and the statistics get each sample clamped, the strata being unknown to them
+				if options.PixelEstimator == PixelClampedStrata {
+					radiance = NewVector3(clamp(radiance.X), clamp(radiance.Y), clamp(radiance.Z))
+				}
+				if r.film != nil {
+					r.film.AddSample(rasterX, rasterY, radiance)
+				}
+				statistics.add(radiance.Luminance())
 			}
 
-			// Sum up pixel color
-			pixelColor = pixelColor.Add(NewVector3(clamp(acc.X), clamp(acc.Y), clamp(acc.Z)).MulScalar(.25))
+			pixelColor = pixelColor.Add(NewVector3(clamp(acc.X), clamp(acc.Y), clamp(acc.Z)).MulScalar(float64(count) / float64(spp)))
+		}
+		batches++
+
+		if statistics.count >= options.minSamplesPerPixel() && options.converged(statistics) {
+			break
 		}
 	}
 
-	pixelColor.X = gammaCorrection(pixelColor.X)
-	pixelColor.Y = gammaCorrection(pixelColor.Y)
-	pixelColor.Z = gammaCorrection(pixelColor.Z)
+	switch options.PixelEstimator {
+	case PixelMean:
+		pixelColor = estimate.mean()
+	case PixelMedianOfMeans:
+		pixelColor = estimate.medianOfMeans()
+	default:
+		pixelColor = pixelColor.MulScalar(1 / float64(batches))
+	}
+	if r.film != nil {
+		r.film.AddSamples(x, y, statistics.count)
+	}
+
+	if r.guide != nil {
+		sum := &r.guide.pixels[y*options.Width+x]
+		*sum = sum.Add(pixelColor)
+		return sum.MulScalar(1 / float64(r.guide.pass+1))
+	}
 
 	return pixelColor
 }
 
-func (r PathTracer) intersect(ray Ray, scene Scene, ignoreLights bool) (hit Hit, index int) {
-	tnear := math.MaxFloat64
-	collisionIndex := -1
+// pathState is what the path tracer knows of the path leading to a ray
+type pathState struct {
+	depth        uint // Bounces made, of any kind
+	diffuse      uint
+	glossy       uint
+	transmission uint
+	throughput   Vector3 // Product of the weights of the bounces
+}
 
-	var nearestHit = Hit{Valid: false}
+func cameraPath() pathState {
+	return pathState{throughput: NewVector3(1, 1, 1)}
+}
 
-	// Compute nearest intersection
-	for i := 0; i < len(scene.Objects); i++ {
-		obj := scene.Objects[i]
-		if ignoreLights && obj.Material().EmissionColor != Vector3Zero {
-			continue
-		}
+// bounce returns the path after a bounce multiplying its throughput by weight
+func (p pathState) bounce(weight Vector3) pathState {
+	p.depth++
+	p.throughput = p.throughput.Mul(weight)
+	return p
+}
 
-		hit := scene.Objects[i].Intersects(ray)
-		if hit.Valid {
-			if hit.Distance < tnear {
-				tnear = hit.Distance
-				collisionIndex = i
-				nearestHit = hit
-			}
-		}
-	}
+func (p pathState) diffuseBounce(weight Vector3) pathState {
+	p.diffuse++
+	return p.bounce(weight)
+}
+
+func (p pathState) glossyBounce(weight Vector3) pathState {
+	p.glossy++
+	return p.bounce(weight)
+}
+
+func (p pathState) transmissionBounce(weight Vector3) pathState {
+	p.transmission++
+	return p.bounce(weight)
+}
 
-	return nearestHit, collisionIndex
+// Glass sends rays both ways during the first bounces, where the noise of choosing one shows the most
+const dielectricSplitDepth = 2
+
+// radiance returns the radiance arriving along a ray, clamped to ClampIndirect after a bounce
+func (r PathTracer) radiance(ray Ray, scene Scene, options RenderingOptions, path pathState, rnd *rand.Rand, E float64, hidden Visibility, medium Medium) Vector3 {
+	l := r.pathRadiance(ray, scene, options, path, rnd, E, hidden, medium)
+	if path.depth > 0 {
+		return clampLuminance(l, options.ClampIndirect)
+	}
+	return l
 }
 
-func (r PathTracer) radiance(ray Ray, scene Scene, options RenderingOptions, depth uint, rnd *rand.Rand, E float64) Vector3 {
-	depth = depth + 1
+func (r PathTracer) pathRadiance(ray Ray, scene Scene, options RenderingOptions, path pathState, rnd *rand.Rand, E float64, hidden Visibility, medium Medium) Vector3 {
+	nearestHit, collisionIndex := scene.intersect(ray, hidden)
+
+	if medium == nil {
+		return r.surfaceRadiance(ray, nearestHit, collisionIndex, scene, options, path, rnd, E, hidden, medium)
+	}
+
+	// Travel through the medium up to the surface hit, unless the ray scatters before
+	tMax := math.Inf(1)
+	if collisionIndex != -1 {
+		tMax = nearestHit.Distance
+	}
+
+	t, scattered, weight, emitted := medium.sampleDistance(ray, tMax, rnd)
+	if weight.IsZero() {
+		return emitted
+	}
+
+	if !scattered {
+		return emitted.Add(weight.Mul(r.surfaceRadiance(ray, nearestHit, collisionIndex, scene, options, path, rnd, E, hidden, medium)))
+	}
+
+	if path.depth >= options.maxDepth() {
+		return emitted
+	}
 
-	// We don't want to draw the light spheres
-	ignoreLights := false
-	if depth == 1 {
-		ignoreLights = true
+	// Russian Roulette
+	p := options.survival(path.depth+1, path.throughput, weight)
+	if p < 1 && rnd.Float64() >= p {
+		return emitted
 	}
+	weight = weight.MulScalar(1 / p)
+
+	point := ray.Origin.Add(ray.Direction.MulScalar(t))
+	wo := ray.Direction.MulScalar(-1)
 
-	nearestHit, collisionIndex := r.intersect(ray, scene, ignoreLights)
+	// Explicit light sampling, then a new direction according to the phase function
+	e := r.mediumDirectLighting(point, wo, medium, scene, options, rnd)
+	wi := medium.samplePhase(wo, rnd.Float64(), rnd.Float64())
+
+	return emitted.Add(weight.Mul(e.Add(r.radiance(NewRay(point, wi), scene, options, path.bounce(weight), rnd, 0, HiddenFromIndirect, medium))))
+}
 
+// surfaceRadiance returns the radiance leaving the surface hit by a ray, towards its origin
+func (r PathTracer) surfaceRadiance(ray Ray, nearestHit Hit, collisionIndex int, scene Scene, options RenderingOptions, path pathState, rnd *rand.Rand, E float64, hidden Visibility, medium Medium) Vector3 {
 	if collisionIndex == -1 {
 		return defaultColor
 	}
@@ -123,26 +287,31 @@ func (r PathTracer) radiance(ray Ray, scene Scene, options RenderingOptions, dep
 		nhitCleaned = nhit.MulScalar(-1)
 	}
 
-	// Russian Roulette
-	p := objectColor.Z
-	if objectColor.X > objectColor.Y && objectColor.X > objectColor.Z {
-		p = objectColor.X
-	} else if objectColor.Y > objectColor.Z {
-		p = objectColor.Y
+	// Invisible boundary of a medium, the ray goes on from the other side
+	if material.isMediumBoundary() {
+		origin := offsetRayOrigin(phit, nhit, ray.Direction)
+		return r.radiance(NewRay(origin, ray.Direction), scene, options, path, rnd, E, hidden, scene.mediumAfter(material, nhit, ray.Direction))
 	}
 
-	if depth > 5 || p == 0 {
-		if rand.Float64() < p {
-			objectColor = objectColor.MulScalar(1 / p)
-		} else {
-			return material.EmissionColor.MulScalar(E)
-		}
+	if path.depth >= options.maxDepth() {
+		return material.EmissionColor.MulScalar(E)
 	}
 
+	// Russian Roulette
+	p := options.survival(path.depth+1, path.throughput, objectColor)
+	if p < 1 && rnd.Float64() >= p {
+		return material.EmissionColor.MulScalar(E)
+	}
+	objectColor = objectColor.MulScalar(1 / p)
+
 	// Pure diffuse material
 	if material.Reflectivity == 0 && material.Transparency == 0 {
-		r1 := 2. * M_PI * rand.Float64()
-		r2 := rand.Float64()
+		if path.diffuse >= options.depthLimit(options.MaxDiffuseDepth) {
+			return material.EmissionColor.MulScalar(E)
+		}
+
+		r1 := 2. * M_PI * rnd.Float64()
+		r2 := rnd.Float64()
 		r2s := math.Sqrt(r2)
 
 		// Create orthonormal coordinate frame (w,u,v)
@@ -161,78 +330,62 @@ func (r PathTracer) radiance(ray Ray, scene Scene, options RenderingOptions, dep
 		// Random reflection ray
 		d := d1.Add(d2).Add(d3).Normalize()
 
-		e := NewVector3(0, 0, 0)
-
-		for i := 0; i < len(scene.Objects); i++ {
-			if scene.Objects[i].Material().EmissionColor == Vector3Zero {
-				continue
-			}
-
-			light := scene.Objects[i]
-			lightMaterial := light.Material()
-			//lightDistance := phit.DistanceTo(light.Position())
-
-			sw := light.Position().Sub(phit)
-			su := NewVector3(1, 1, 1)
-			if math.Abs(sw.X) > .1 {
-				su = NewVector3(0, 1, 0)
-			}
-			su = su.Cross(sw).Normalize()
-			sv := sw.Cross(su).Normalize()
-
-			p := phit.Sub(light.Position())
-			rad := 1.5 // TODO: ?
-
-			cos_a_max := math.Sqrt(1 - (rad*rad)/p.Dot(p))
-			eps1 := rand.Float64()
-			eps2 := rand.Float64()
-			cos_a := 1 - eps1 + eps1*cos_a_max
-			sin_a := math.Sqrt(1 - cos_a*cos_a)
-			phi := 2 * M_PI * eps2
-
-			l1 := su.MulScalar(math.Cos(phi) * sin_a)
-			l2 := sv.MulScalar(math.Sin(phi) * sin_a)
-			l3 := sw.MulScalar(cos_a)
+		// Explicit light sampling
+		e := r.directLighting(phit, nhitCleaned, objectColor, collisionIndex, scene, options, rnd, medium)
 
-			lightDirection := l1.Add(l2).Add(l3).Normalize()
-
-			lightRay := NewRay(phit, lightDirection)
+		if r.cache != nil {
+			irradiance := r.indirectIrradiance(phit, nhitCleaned, scene, options, path.diffuseBounce(objectColor), rnd, medium)
+			return material.EmissionColor.MulScalar(E).
+				Add(e).
+				Add(objectColor.Mul(irradiance).MulScalar(M_1_PI))
+		}
 
-			collision, index := r.intersect(lightRay, scene, false)
+		if r.guide == nil {
+			return material.EmissionColor.MulScalar(E).
+				Add(e).
+				Add(objectColor.Mul(r.radiance(NewRay(phit, d), scene, options, path.diffuseBounce(objectColor), rnd, 0, HiddenFromIndirect, medium)))
+		}
 
-			// The only collision was the light itself
-			if collision.Valid && index == i {
-				omega := 2 * M_PI * (1 - cos_a_max)
-				e = e.Add(objectColor.Mul(lightMaterial.EmissionColor.MulScalar(lightDirection.Dot(nhitCleaned) * omega)).MulScalar(M_1_PI))
-			}
+		d, weight, pdf := r.guide.sampleDiffuse(phit, nhitCleaned, d, objectColor, r.guidingBSDFFraction(), rnd)
+		if weight.IsZero() {
+			return material.EmissionColor.MulScalar(E).Add(e)
 		}
 
+		indirect := r.radiance(NewRay(phit, d), scene, options, path.diffuseBounce(weight), rnd, 0, HiddenFromIndirect, medium)
+		r.guide.record(phit, d, indirect, pdf)
+
 		return material.EmissionColor.MulScalar(E).
 			Add(e).
-			Add(objectColor.Mul(r.radiance(NewRay(phit, d), scene, options, depth, rnd, 0)))
+			Add(weight.Mul(indirect))
 	}
 
 	reflectionDirection := ray.Direction.Sub(nhit.MulScalar(2 * nhit.Dot(ray.Direction)))
 	reflectionRay := NewRay(phit, reflectionDirection)
+	canReflect := path.glossy < options.depthLimit(options.MaxGlossyDepth)
+
+	reflection := func(weight Vector3) Vector3 {
+		if !canReflect {
+			return Vector3Zero
+		}
+		return weight.Mul(r.radiance(reflectionRay, scene, options, path.glossyBounce(weight), rnd, 1, HiddenFromReflections, medium))
+	}
 
 	// Specular reflection
 	if material.Transparency == 0 {
-		return material.EmissionColor.
-			Add(objectColor.Mul(r.radiance(reflectionRay, scene, options, depth, rnd, 1)))
+		return material.EmissionColor.Add(reflection(objectColor))
 	}
 
 	// Reflection + Refraction (dielectric (glass))
 	into := nhit.Dot(nhitCleaned) > 0
 	nc := 1.
-	nt := 1.5
+	nt := material.ior()
 	nnt := ternaryFloat64(into, nc/nt, nt/nc)
 	ddn := ray.Direction.Dot(nhitCleaned)
 	cost2t := 1 - nnt*nnt*(1-ddn*ddn)
 
 	// Total internal reflection
 	if cost2t < 0 {
-		return material.EmissionColor.
-			Add(objectColor.Mul(r.radiance(reflectionRay, scene, options, depth, rnd, 1)))
+		return material.EmissionColor.Add(reflection(objectColor))
 	}
 
 	// Choose reflection or refraction
@@ -242,6 +395,14 @@ func (r PathTracer) radiance(ray Ray, scene Scene, options RenderingOptions, dep
 	tdir2 := nhit.MulScalar(coeff * (ddn * nnt * math.Sqrt(cost2t)))
 	tdir := tdir1.Sub(tdir2).Normalize()
 
+	refraction := func(weight Vector3) Vector3 {
+		if path.transmission >= options.depthLimit(options.MaxTransmissionDepth) {
+			return Vector3Zero
+		}
+		medium := scene.mediumAfter(material, nhit, tdir)
+		return weight.Mul(r.radiance(NewRay(phit, tdir), scene, options, path.transmissionBounce(weight), rnd, 1, HiddenFromReflections, medium))
+	}
+
 	a := nt - nc
 	b := nt + nc
 	c := 1 - ternaryFloat64(into, -ddn, tdir.Dot(nhit))
@@ -252,22 +413,82 @@ func (r PathTracer) radiance(ray Ray, scene Scene, options RenderingOptions, dep
 	RP := Re / P
 	TP := Tr / (1 - P)
 
-	// TODO ?
-	colorDelta := Vector3Zero
-	if depth > 2 {
-		// Russian Roulette
-		if rand.Float64() < P {
-			colorDelta = r.radiance(reflectionRay, scene, options, depth, rnd, 1).MulScalar(RP)
-		} else {
-			colorDelta = r.radiance(NewRay(phit, tdir), scene, options, depth, rnd, 1).MulScalar(TP)
+	if path.depth < dielectricSplitDepth {
+		return material.EmissionColor.
+			Add(reflection(objectColor.MulScalar(Re))).
+			Add(refraction(objectColor.MulScalar(Tr)))
+	}
+
+	if rnd.Float64() < P {
+		return material.EmissionColor.Add(reflection(objectColor.MulScalar(RP)))
+	}
+	return material.EmissionColor.Add(refraction(objectColor.MulScalar(TP)))
+}
+
+// directLighting estimates the light reaching a diffuse point of an object directly from the emitters of the scene
+func (r PathTracer) directLighting(phit, normal, color Vector3, object int, scene Scene, options RenderingOptions, rnd *rand.Rand, medium Medium) Vector3 {
+	diffuse := func(direction Vector3) Vector3 {
+		cos := direction.Dot(normal)
+		if cos <= 0 {
+			return Vector3Zero
+		}
+		return color.MulScalar(cos * M_1_PI)
+	}
+
+	return r.sampleLights(phit, normal, object, diffuse, scene, options, rnd, medium)
+}
+
+// mediumDirectLighting estimates the light scattered towards wo at a point of a medium directly from the emitters of the scene
+func (r PathTracer) mediumDirectLighting(p, wo Vector3, medium Medium, scene Scene, options RenderingOptions, rnd *rand.Rand) Vector3 {
+	phase := func(direction Vector3) Vector3 {
+		f := medium.phase(wo, direction)
+		return NewVector3(f, f, f)
+	}
+
+	return r.sampleLights(p, Vector3Zero, -1, phase, scene, options, rnd, medium)
+}
+
+// sampleLights estimates the light reaching a point from the emitters, scattered according to the given function
+// of the direction towards the light. The object is the one the point belongs to, -1 inside a medium.
+func (r PathTracer) sampleLights(p, normal Vector3, object int, scattering func(Vector3) Vector3, scene Scene, options RenderingOptions, rnd *rand.Rand, medium Medium) Vector3 {
+	lights := scene.lightSet(options)
+
+	if lights.strategy == SampleAllLights {
+		e := NewVector3(0, 0, 0)
+		for i, light := range lights.emitters {
+			if scene.illuminates(lights.ids[i], object) {
+				e = e.Add(r.sampleEmitter(light, p, scattering, scene, rnd, medium))
+			}
 		}
-	} else {
-		c1 := r.radiance(reflectionRay, scene, options, depth, rnd, 1).MulScalar(Re)
-		c2 := r.radiance(NewRay(phit, tdir), scene, options, depth, rnd, 1).MulScalar(Tr)
-		colorDelta = c1.Add(c2)
+		return e
+	}
+
+	i, pmf := lights.choose(p, normal, rnd.Float64())
+	if i < 0 || !scene.illuminates(lights.ids[i], object) {
+		return Vector3Zero
+	}
+
+	return r.sampleEmitter(lights.emitters[i], p, scattering, scene, rnd, medium).MulScalar(1 / pmf)
+}
+
+func (r PathTracer) sampleEmitter(light emitter, p Vector3, scattering func(Vector3) Vector3, scene Scene, rnd *rand.Rand, medium Medium) Vector3 {
+	sample := light.sample(p, rnd.Float64(), rnd.Float64())
+	if sample.pdf == 0 {
+		return Vector3Zero
+	}
+
+	f := scattering(sample.direction)
+	if f.IsZero() {
+		return Vector3Zero
 	}
 
-	return material.EmissionColor.Add(material.Color.Mul(colorDelta))
+	// Nothing should stand between the point and the light, media attenuate it
+	tr := scene.transmittance(p, sample.direction, sample.distance, medium, rnd)
+	if tr.IsZero() {
+		return Vector3Zero
+	}
+
+	return f.Mul(tr).Mul(sample.radiance.MulScalar(1 / sample.pdf))
 }
 
 func clamp(x float64) float64 {
@@ -282,11 +503,6 @@ func clamp(x float64) float64 {
 	return x
 }
 
-func gammaCorrection(x float64) float64 {
-	// Gamma correction of 2.2
-	return math.Pow(clamp(x), 1./2.2)*255. + .5
-}
-
 func ternaryFloat64(condition bool, a, b float64) float64 {
 	if condition {
 		return a
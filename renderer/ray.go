@@ -17,3 +17,14 @@ func NewRay(origin, direction Vector3) Ray {
 		Direction: direction,
 	}
 }
+
+// rayEpsilon offsets the origin of rays leaving a surface, so that they do not hit it again
+const rayEpsilon = 1e-4
+
+// offsetRayOrigin moves a point off its surface, on the side the direction goes to
+func offsetRayOrigin(p, normal, direction Vector3) Vector3 {
+	if normal.Dot(direction) < 0 {
+		normal = normal.MulScalar(-1)
+	}
+	return p.Add(normal.MulScalar(rayEpsilon))
+}
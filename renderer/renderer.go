@@ -3,103 +3,212 @@ package renderer
 import (
 	"fmt"
 	"image"
-	"image/color"
 	"image/png"
+	"math"
 	"math/rand"
 	"os"
+	"os/signal"
+	"runtime"
 	"sync"
 	"time"
 )
 
-type Pixel struct {
-	x     uint
-	y     uint
-	color Vector3
-}
+// workers is the number of goroutines sampling the pixels, and shooting the photons or the bootstrap paths
+var workers = runtime.NumCPU()
 
 // Render computes and write the result as a PNG file
 func Render(sampler Sampler, options RenderingOptions, camera Camera, scene Scene) {
-	const maxThreads = 8
+	outputQueue := make(chan tile)
 
-	inputQueue := make(chan Pixel)
-	outputQueue := make(chan Pixel)
+	if options.Seed == 0 {
+		options.Seed = time.Now().UnixNano()
+		fmt.Printf("Seed %v\n", options.Seed)
+	}
 
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	scene = scene.prepare(options)
 
 	var wgImageBuilder sync.WaitGroup
 	wgImageBuilder.Add(1)
 
+	film := NewFilm(options.Width, options.Height)
+	if filter := options.filter(); filter != nil {
+		film.setFilter(filter, options.estimatorGroups())
+	}
+	if s, ok := sampler.(FilmSampler); ok {
+		sampler = s.WithFilm(film)
+	}
+
+	// Samplers making passes of their own compute the value of the pixels anew at each one
+	passes := options.passes()
+	replace := false
+	if s, ok := sampler.(PassSampler); ok && s.Passes() > 0 {
+		passes = s.Passes()
+		replace = true
+	}
+
+	// With a budget, progressive passes are added until it is reached, Passes being the most there can be
+	budgeted := !replace && (options.TimeBudget > 0 || options.TargetNoise > 0)
+	if budgeted && options.Passes == 0 {
+		passes = math.MaxInt32
+	}
+
+	// Interrupting a progressive render stops it once the current pass is over
+	interrupt := make(chan os.Signal, 1)
+	if passes > 1 {
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+	}
+
+	passDone := make(chan struct{})
+
 	// Start image building thread
-	m := image.NewRGBA(image.Rect(0, 0, int(options.Width), int(options.Height)))
 	go func() {
 		defer wgImageBuilder.Done()
 
-		totalPixels := options.Width * options.Height
+		pixelsPerPass := options.Width * options.Height
+		totalPixels := pixelsPerPass * uint(passes)
 		processedPixels := uint(0)
 		progress := uint(0)
 
 		precision := uint(25)
-		reportingStep := uint(float64(totalPixels) * (float64(precision) / 100.))
-
-		for p := range outputQueue {
-			c := color.RGBA{
-				uint8(p.color.X),
-				uint8(p.color.Y),
-				uint8(p.color.Z),
-				255,
+		reportingStep := uint(math.Max(1, float64(totalPixels)*(float64(precision)/100.)))
+
+		// The films of the tiles are merged in the order of the tiles, whichever worker completes first,
+		// so that the sums of the filtered samples are the same from one render to the next
+		tileFilms := map[int]*Film{}
+		nextTile := 0
+
+		for t := range outputQueue {
+			if t.film != nil {
+				tileFilms[t.index] = t.film
+				for tf, ok := tileFilms[nextTile]; ok; tf, ok = tileFilms[nextTile] {
+					film.merge(tf)
+					delete(tileFilms, nextTile)
+					nextTile++
+				}
 			}
-			m.Set(int(p.x), int(p.y), c)
 
-			processedPixels++
-			if processedPixels%reportingStep == 0 {
-				progress++
+			i := 0
+			for y := t.y0; y < t.y1; y++ {
+				for x := t.x0; x < t.x1; x++ {
+					if replace {
+						film.Set(x, y, t.colors[i])
+					} else {
+						film.Add(x, y, t.colors[i])
+					}
+					i++
+				}
+			}
+
+			processedPixels += t.pixels()
+			if !budgeted && processedPixels >= (progress+1)*reportingStep {
+				progress = processedPixels / reportingStep
 				fmt.Printf("%v%% completed\n", progress*precision)
-				writeImage(m)
+				writeImage("rgb.png", film.Image())
+			}
+
+			if processedPixels%pixelsPerPass == 0 {
+				nextTile = 0
+				film.EndPass()
+				if passes > 1 {
+					writeImage("rgb.png", film.Image())
+				}
+				passDone <- struct{}{}
 			}
 		}
 	}()
 
-	var wg sync.WaitGroup
-	wg.Add(maxThreads)
+	start := time.Now()
+	completed := 0
 
-	// Spawn workers
-	for i := 0; i < maxThreads; i++ {
-		go worker(sampler, options, camera, scene, rnd, inputQueue, outputQueue, &wg)
-	}
+passes:
+	for pass := 0; pass < passes; pass++ {
+		select {
+		case <-interrupt:
+			fmt.Printf("Stopped after %v passes\n", pass)
+			break passes
+		default:
+		}
 
-	// Enqueue all pixels
-	for y := uint(0); y < options.Height; y++ {
-		for x := uint(0); x < options.Width; x++ {
-			inputQueue <- Pixel{x: x, y: y}
+		if budgeted && pass > 0 && options.budgetReached(film, time.Since(start), pass) {
+			break
 		}
-	}
-	close(inputQueue)
 
-	// Wait for workers to finish
-	wg.Wait()
+		if s, ok := sampler.(PassSampler); ok {
+			sampler = s.BeginPass(pass, camera, scene, options)
+		}
+		options.pass = pass
+
+		// All the tiles are queued at once, the workers taking them in order
+		tiles := options.tiles()
+		inputQueue := make(chan tile, len(tiles))
+		for _, t := range tiles {
+			inputQueue <- t
+		}
+		close(inputQueue)
+
+		var wg sync.WaitGroup
+		wg.Add(workers)
+
+		// Spawn workers
+		for i := 0; i < workers; i++ {
+			go worker(sampler, film, options, camera, scene, inputQueue, outputQueue, &wg)
+		}
+
+		// Wait for workers to finish the pass, and for the film to get all its pixels
+		wg.Wait()
+		<-passDone
+		completed++
+
+		if budgeted {
+			fmt.Printf("Pass %v completed%v\n", completed, film.summary())
+		}
+	}
 	close(outputQueue)
 
 	// Wait for image to be composed
 	wgImageBuilder.Wait()
 
+	elapsed := time.Since(start)
+	throughput := float64(uint(completed)*options.Width*options.Height) / math.Max(elapsed.Seconds(), 1e-9)
+	fmt.Printf("Rendered %v passes in %v%v, %.0f pixels per second\n", completed, elapsed.Round(time.Millisecond), film.summary(), throughput)
 	fmt.Println("Result saved to rgb.png")
-	writeImage(m)
+	writeImage("rgb.png", film.Image())
+
+	if options.SampleHeatmap {
+		fmt.Println("Samples per pixel saved to samples.png")
+		writeImage("samples.png", film.SamplesImage())
+	}
 }
 
-func worker(sampler Sampler, options RenderingOptions, camera Camera, scene Scene, rnd *rand.Rand, inputQueue chan Pixel, outputQueue chan Pixel, wg *sync.WaitGroup) {
+// worker renders whole tiles into their own buffer, handed to the image builder once complete
+func worker(sampler Sampler, film *Film, options RenderingOptions, camera Camera, scene Scene, inputQueue chan tile, outputQueue chan tile, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for p := range inputQueue {
-		x := p.x
-		y := p.y
+	for t := range inputQueue {
+		t.colors = make([]Vector3, 0, t.pixels())
+
+		// With a filter, the samples of the tile are gathered on a film of its own, merged by the image builder
+		tileSampler := sampler
+		if s, ok := sampler.(FilmSampler); ok && film.filter != nil {
+			t.film = film.tileFilm(t)
+			tileSampler = s.WithFilm(t.film)
+		}
 
-		p.color = sampler.Sample(x, y, camera, scene, options, rnd)
-		outputQueue <- p
+		for y := t.y0; y < t.y1; y++ {
+			for x := t.x0; x < t.x1; x++ {
+				// Each pixel has its own random numbers, whichever worker samples it
+				rnd := newRandom(options.Seed, uint64(options.pass), uint64(x), uint64(y))
+				t.colors = append(t.colors, tileSampler.Sample(x, y, camera, scene, options, rnd))
+			}
+		}
+
+		outputQueue <- t
 	}
 }
 
-func writeImage(m *image.RGBA) {
-	f, err := os.OpenFile("rgb.png", os.O_WRONLY|os.O_CREATE, 0600)
+func writeImage(path string, m *image.RGBA) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -108,3 +217,30 @@ func writeImage(m *image.RGBA) {
 	png.Encode(f, m)
 	defer f.Close()
 }
+
+// splitMix64 is a small and fast random source, so that one can be seeded for each pixel
+// See: https://prng.di.unimi.it/splitmix64.c
+type splitMix64 struct {
+	state uint64
+}
+
+func (s *splitMix64) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func (s *splitMix64) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+// newRandom returns random numbers depending only on the seed of the render and the given values
+func newRandom(seed int64, a, b, c uint64) *rand.Rand {
+	return rand.New(&splitMix64{state: hash3(hash3(uint64(seed), a, b), c, 0)})
+}
@@ -1,8 +1,295 @@
 package renderer
 
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
 type RenderingOptions struct {
-	Width    uint
-	Height   uint
-	Fov      float64
-	MaxDepth uint
+	Width         uint
+	Height        uint
+	Fov           float64
+	MaxDepth      uint // Bounces of the paths, 5 when zero
+	LightSampling LightSampling
+	Exposure      float64 // In stops, scales the radiance before tone mapping
+
+	// Bounces of the paths of the path tracer. MaxDepth bounds all of them, and each kind of bounce can be bounded
+	// further, MaxDepth being the only limit when zero. Mirror reflections, glass included, are glossy bounces.
+	MaxDiffuseDepth      uint
+	MaxGlossyDepth       uint
+	MaxTransmissionDepth uint
+
+	// Russian roulette randomly ends the paths of the path tracer after RouletteDepth bounces, 5 when zero.
+	// The paths going on are weighted up, so that the image stays the same on average.
+	Roulette      Roulette
+	RouletteDepth uint
+
+	SamplesPerPixel uint // 16 when zero
+	Stratification  Stratification
+	SampleGenerator SampleGenerator
+
+	// Reconstruction of the image from the samples of the path tracer and the bidirectional path tracer,
+	// and from the splats of all the samplers. With a filter, the image is the weighted mean of the samples around
+	// each pixel, as PixelEstimator sees them: PixelMedianOfMeans reconstructs each group of samples apart.
+	// FilterRadius is in pixels, and depends on the filter when zero.
+	Filter       Filter
+	FilterRadius float64
+
+	// Adaptive sampling, for the path tracer. Pixels are sampled SamplesPerPixel at a time, until the standard
+	// error of their luminance falls below AdaptiveThreshold times the luminance, or MaxSamplesPerPixel is reached.
+	// Disabled when zero.
+	AdaptiveThreshold  float64
+	MinSamplesPerPixel uint // SamplesPerPixel when zero
+	MaxSamplesPerPixel uint // 4 times SamplesPerPixel when zero
+	SampleHeatmap      bool // Also writes samples.png, showing the samples each pixel got from blue to red
+
+	// Firefly suppression, for the path tracer, at the cost of some bias. The luminance of each camera sample
+	// is clamped to ClampSamples, and that of the light arriving after a bounce to ClampIndirect, in radiance
+	// before exposure, the color being kept. Disabled when zero. A reference render takes the plain mean
+	// of the samples, with PixelMean and no clamping.
+	ClampSamples        float64
+	ClampIndirect       float64
+	PixelEstimator      PixelEstimator
+	MedianOfMeansGroups uint // 5 when zero
+
+	// Progressive rendering: the image is refined by passes of SamplesPerPixel samples, averaged on the film
+	// and saved after each one. Interrupting the render stops it after the current pass.
+	// Samplers making passes of their own, such as SPPM, ignore it. 1 when zero.
+	Passes uint
+
+	// Budget of a progressive render, which goes on with new passes until one is reached. A pass is only started
+	// if it should end within TimeBudget. TargetNoise is the relative standard error of the pixels, estimated
+	// from the differences between the passes, below which the render stops.
+	TimeBudget  time.Duration
+	TargetNoise float64
+
+	// Seed of the random numbers, a render being the same for the same seed whatever the number of workers,
+	// which is the number of CPUs.
+	// Picked from the time when zero. Samplers sharing what they learn between pixels, such as path guiding
+	// and the irradiance cache, or splatting onto the film, depend on the order the pixels complete in.
+	Seed int64
+
+	// Workers render square tiles of TileSize pixels at a time, 32 when zero, handed to them in TileOrder
+	TileSize  uint
+	TileOrder TileOrder
+
+	pass int // Pass being rendered, set by Render
+}
+
+// Stratification selects how the samples of a pixel are spread over its area.
+// Stratified pixels are split into a grid of cells, as many per side as the square root of the samples allows.
+type Stratification uint
+
+const (
+	// StratifyTent spreads the samples of each cell with a tent filter, two cells wide, around its center,
+	// unless the image is reconstructed with a Filter
+	StratifyTent Stratification = iota
+	// StratifyJittered picks the samples of each cell uniformly within it
+	StratifyJittered
+	// StratifyNone picks the samples uniformly over the whole pixel
+	StratifyNone
+)
+
+// PixelEstimator selects how the samples of a pixel are combined into its value
+type PixelEstimator uint
+
+const (
+	// PixelClampedStrata averages the samples of each cell of the pixel, clamped to the displayable range
+	// before the cells are averaged, which keeps the fireflies of a few paths from spreading.
+	// Adaptive sampling and a reconstruction filter, which cannot tell the cells apart, get each sample clamped.
+	PixelClampedStrata PixelEstimator = iota
+	// PixelMean is the plain mean of the samples
+	PixelMean
+	// PixelMedianOfMeans deals the samples into groups, and keeps the mean of the group with the median luminance,
+	// rejecting the groups with outliers. It is darker than the mean where a few samples carry most of the light.
+	PixelMedianOfMeans
+)
+
+// Roulette selects the probability with which paths go on at each bounce once the roulette has started
+type Roulette uint
+
+const (
+	// RouletteAlbedo goes on with the largest component of the color of the surface hit
+	RouletteAlbedo Roulette = iota
+	// RouletteThroughput goes on with the largest component of the throughput of the path,
+	// so that paths carrying little light end first
+	RouletteThroughput
+	// RouletteNone only ends the paths which cannot carry light anymore
+	RouletteNone
+)
+
+const (
+	defaultSamplesPerPixel     = 16
+	defaultMaxDepth            = 5
+	defaultRouletteDepth       = 5
+	defaultMedianOfMeansGroups = 5
+)
+
+// exposureScale returns the factor applied to the radiance
+func (o RenderingOptions) exposureScale() float64 {
+	return math.Exp2(o.Exposure)
+}
+
+func (o RenderingOptions) samplesPerPixel() int {
+	if o.SamplesPerPixel == 0 {
+		return defaultSamplesPerPixel
+	}
+	return int(o.SamplesPerPixel)
+}
+
+// estimatorGroups returns the groups the samples of a pixel are dealt into, 1 unless for the median of means
+func (o RenderingOptions) estimatorGroups() int {
+	if o.PixelEstimator != PixelMedianOfMeans {
+		return 1
+	}
+	if o.MedianOfMeansGroups == 0 {
+		return defaultMedianOfMeansGroups
+	}
+	return int(o.MedianOfMeansGroups)
+}
+
+// clampLuminance scales c down to the given luminance if it is brighter, unless max is zero
+func clampLuminance(c Vector3, max float64) Vector3 {
+	l := c.Luminance()
+	if max <= 0 || l <= max {
+		return c
+	}
+	return c.MulScalar(max / l)
+}
+
+func (o RenderingOptions) rouletteDepth() uint {
+	if o.RouletteDepth == 0 {
+		return defaultRouletteDepth
+	}
+	return o.RouletteDepth
+}
+
+// survival returns the probability of a path to go on after a bounce of the given depth, weight being what
+// the bounce multiplies the throughput of the path by
+func (o RenderingOptions) survival(depth uint, throughput, weight Vector3) float64 {
+	p := weight.MaxComponent()
+	if p <= 0 {
+		return 0
+	}
+	if depth <= o.rouletteDepth() {
+		return 1
+	}
+
+	switch o.Roulette {
+	case RouletteThroughput:
+		p = throughput.Mul(weight).MaxComponent()
+	case RouletteNone:
+		return 1
+	}
+	return math.Min(1, p)
+}
+
+func (o RenderingOptions) maxDepth() uint {
+	if o.MaxDepth == 0 {
+		return defaultMaxDepth
+	}
+	return o.MaxDepth
+}
+
+// depthLimit returns the bounces of a kind a path can make, MaxDepth when zero
+func (o RenderingOptions) depthLimit(max uint) uint {
+	if max == 0 || max > o.maxDepth() {
+		return o.maxDepth()
+	}
+	return max
+}
+
+func (o RenderingOptions) passes() int {
+	if o.Passes == 0 {
+		return 1
+	}
+	return int(o.Passes)
+}
+
+// budgetReached tells whether a progressive render should stop after the given passes
+func (o RenderingOptions) budgetReached(film *Film, elapsed time.Duration, passes int) bool {
+	if o.TimeBudget > 0 && elapsed+elapsed/time.Duration(passes) > o.TimeBudget {
+		return true
+	}
+	if o.TargetNoise > 0 {
+		if e, ok := film.Error(); ok && e <= o.TargetNoise {
+			return true
+		}
+	}
+	return false
+}
+
+func (o RenderingOptions) minSamplesPerPixel() int {
+	if o.MinSamplesPerPixel == 0 {
+		return o.samplesPerPixel()
+	}
+	return int(o.MinSamplesPerPixel)
+}
+
+// maxSamplesPerPixel returns the samples a pixel gets at most, SamplesPerPixel without adaptive sampling
+func (o RenderingOptions) maxSamplesPerPixel() int {
+	if o.AdaptiveThreshold <= 0 {
+		return o.samplesPerPixel()
+	}
+	if o.MaxSamplesPerPixel == 0 {
+		return 4 * o.samplesPerPixel()
+	}
+	return int(math.Max(float64(o.MaxSamplesPerPixel), float64(o.minSamplesPerPixel())))
+}
+
+// converged tells whether a pixel has enough samples, its error being low enough.
+// Dark pixels are compared to a minimum luminance, so that they do not need endless samples.
+func (o RenderingOptions) converged(s sampleStatistics) bool {
+	if o.AdaptiveThreshold <= 0 {
+		return true
+	}
+	return s.count > 1 && s.standardError() <= o.AdaptiveThreshold*math.Max(s.mean, adaptiveMinLuminance)
+}
+
+const adaptiveMinLuminance = .01
+
+// strataPerSide returns the number of cells of the grid along each side of the pixel
+func (o RenderingOptions) strataPerSide() int {
+	if o.Stratification == StratifyNone {
+		return 1
+	}
+	return int(math.Max(1, math.Floor(math.Sqrt(float64(o.samplesPerPixel())))))
+}
+
+// stratumSamples returns how many samples go into a cell. When they cannot be shared evenly, the first cells get one more.
+func (o RenderingOptions) stratumSamples(stratum int) int {
+	spp := o.samplesPerPixel()
+	strata := o.strataPerSide() * o.strataPerSide()
+
+	count := spp / strata
+	if stratum < spp%strata {
+		count++
+	}
+	return count
+}
+
+// pixelSample returns a raster position in a cell of the pixel
+func (o RenderingOptions) pixelSample(x, y uint, stratum int, rnd *rand.Rand) (float64, float64) {
+	n := o.strataPerSide()
+	sx := float64(stratum % n)
+	sy := float64(stratum / n)
+
+	// A reconstruction filter weights the samples on its own, they are spread uniformly
+	var dx, dy float64
+	if o.Stratification == StratifyTent && o.Filter == FilterNone {
+		dx = .5 + tent(rnd.Float64())
+		dy = .5 + tent(rnd.Float64())
+	} else {
+		dx = rnd.Float64()
+		dy = rnd.Float64()
+	}
+
+	return float64(x) + (sx+dx)/float64(n), float64(y) + (sy+dy)/float64(n)
+}
+
+// tent maps a uniform number in [0, 1) to [-1, 1] following a tent distribution
+func tent(u float64) float64 {
+	r := 2 * u
+	return ternaryFloat64(r < 1, math.Sqrt(r)-1., 1.-math.Sqrt(2.-r))
 }
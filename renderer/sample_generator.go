@@ -0,0 +1,362 @@
+package renderer
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"sync"
+)
+
+// SampleGenerator selects where the random numbers of the samples of a pixel come from.
+// A sample is a sequence of numbers, its dimensions, consumed in order by the integrators: the first two place
+// the sample on the pixel, the next ones choose the lights and the bounces. Except for IndependentSamples,
+// a given pixel, sample and dimension always get the same number, spread evenly with the other samples.
+type SampleGenerator uint
+
+const (
+	// IndependentSamples draws every number independently
+	IndependentSamples SampleGenerator = iota
+	// StratifiedSamples splits every dimension in as many strata as there are samples, shuffled between dimensions
+	StratifiedSamples
+	// HaltonSamples uses the Halton sequence, randomly shifted for each pixel
+	HaltonSamples
+	// SobolSamples uses pairs of dimensions of the Sobol sequence, Owen scrambled for each pixel
+	SobolSamples
+	// BlueNoiseSamples shifts the same Sobol sequence by a blue noise mask, so that the error looks like blue noise
+	BlueNoiseSamples
+)
+
+// pixelSamples hands out the numbers of the samples of a pixel. It is a random source,
+// so that rand.Rand.Float64 returns them unchanged and integrators keep drawing from a *rand.Rand.
+type pixelSamples struct {
+	generator SampleGenerator
+	x, y      uint
+	spp       int
+	seed      uint64 // Of the pixel
+	sequence  uint64 // Of the whole image
+	rnd       *rand.Rand
+
+	index     uint64
+	dimension uint64
+}
+
+// newPixelSamples returns the samples of a pixel, spp being the number of samples the pixel gets in a pass.
+// rnd is used by IndependentSamples and past the dimensions the other generators cover.
+func (o RenderingOptions) newPixelSamples(x, y uint, spp int, rnd *rand.Rand) *pixelSamples {
+	return &pixelSamples{
+		generator: o.SampleGenerator,
+		x:         x,
+		y:         y,
+		spp:       int(math.Max(1, float64(spp))),
+		seed:      hash3(uint64(x), uint64(y), uint64(o.Seed)),
+		sequence:  hash3(uint64(o.Seed), 0, 0),
+		rnd:       rnd,
+	}
+}
+
+// startSample goes to the first dimension of a sample of the pixel
+func (s *pixelSamples) startSample(index int) {
+	s.index = uint64(index)
+	s.dimension = 0
+}
+
+func (s *pixelSamples) next() float64 {
+	d := s.dimension
+	s.dimension++
+
+	var v float64
+	switch s.generator {
+	case StratifiedSamples:
+		v = s.stratified(d)
+	case HaltonSamples:
+		v = s.halton(d)
+	case SobolSamples:
+		v = s.sobol(d, s.seed)
+	case BlueNoiseSamples:
+		v = s.sobol(d, s.sequence) + blueNoise(s.x, s.y, d)
+		v -= math.Floor(v)
+	default:
+		return s.rnd.Float64()
+	}
+
+	return math.Min(v, oneMinusEpsilon)
+}
+
+// Int63 makes the samples a rand.Source
+func (s *pixelSamples) Int63() int64 {
+	return int64(s.next() * (1 << 63))
+}
+
+func (s *pixelSamples) Seed(int64) {}
+
+const oneMinusEpsilon = 1 - 1./(1<<53)
+
+// stratified picks a stratum of the dimension for the sample, a shuffle of the strata following each run of spp samples
+func (s *pixelSamples) stratified(d uint64) float64 {
+	spp := uint64(s.spp)
+	permutation := hash3(s.seed, d, s.index/spp)
+	stratum := permutationElement(uint32(s.index%spp), uint32(spp), uint32(permutation))
+	jitter := hashFloat(hash3(s.seed, d, s.index|1<<63))
+	return (float64(stratum) + jitter) / float64(spp)
+}
+
+// halton uses one prime base per dimension, shifted for each pixel (Cranley-Patterson rotation)
+func (s *pixelSamples) halton(d uint64) float64 {
+	if d >= uint64(len(haltonPrimes)) {
+		return s.rnd.Float64()
+	}
+
+	v := radicalInverse(haltonPrimes[d], s.index) + hashFloat(hash3(s.seed, d, 1))
+	return v - math.Floor(v)
+}
+
+// sobol pads the first two dimensions of the Sobol sequence: every pair of dimensions gets its own
+// scrambling of the sample index and of the values, decorrelating them
+// See: https://jcgt.org/published/0009/04/01/
+func (s *pixelSamples) sobol(d uint64, seed uint64) float64 {
+	pairSeed := hash3(seed, d/2, 2)
+	index := nestedUniformScramble(uint32(s.index), uint32(pairSeed))
+
+	var v uint32
+	if d%2 == 0 {
+		v = bits.Reverse32(index)
+	} else {
+		v = sobolSecondDimension(index)
+	}
+
+	v = nestedUniformScramble(v, uint32(hash3(pairSeed, d%2, 3)))
+	return float64(v) / (1 << 32)
+}
+
+func sobolSecondDimension(index uint32) uint32 {
+	var v uint32
+	direction := uint32(1 << 31)
+	for ; index != 0; index >>= 1 {
+		if index&1 != 0 {
+			v ^= direction
+		}
+		direction ^= direction >> 1
+	}
+	return v
+}
+
+// nestedUniformScramble is an Owen scrambling of the bits of x
+func nestedUniformScramble(x, seed uint32) uint32 {
+	x = bits.Reverse32(x)
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return bits.Reverse32(x)
+}
+
+// permutationElement returns the element i of a random permutation of [0, l) chosen by p
+// See: https://graphics.pixar.com/library/MultiJitteredSampling/paper.pdf
+func permutationElement(i, l, p uint32) uint32 {
+	w := l - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+
+	for {
+		i ^= p
+		i *= 0xe170893d
+		i ^= p >> 16
+		i ^= (i & w) >> 4
+		i ^= p >> 8
+		i *= 0x0929eb3f
+		i ^= p >> 23
+		i ^= (i & w) >> 1
+		i *= 1 | p>>27
+		i *= 0x6935fa69
+		i ^= (i & w) >> 11
+		i *= 0x74dcb303
+		i ^= (i & w) >> 2
+		i *= 0x9e501cc3
+		i ^= (i & w) >> 2
+		i *= 0xc860a3df
+		i &= w
+		i ^= i >> 5
+		if i < l {
+			break
+		}
+	}
+
+	return (i + p) % l
+}
+
+func radicalInverse(base uint64, index uint64) float64 {
+	inverseBase := 1 / float64(base)
+	inverse := 0.
+	scale := inverseBase
+	for index > 0 {
+		inverse += float64(index%base) * scale
+		index /= base
+		scale *= inverseBase
+	}
+	return inverse
+}
+
+// haltonPrimes are the bases of the dimensions of the Halton sequence
+var haltonPrimes = func() []uint64 {
+	const max = 1024
+
+	var primes []uint64
+	composite := make([]bool, max)
+	for i := 2; i < max; i++ {
+		if composite[i] {
+			continue
+		}
+		primes = append(primes, uint64(i))
+		for j := i * i; j < max; j += i {
+			composite[j] = true
+		}
+	}
+	return primes
+}()
+
+func mixBits(v uint64) uint64 {
+	v ^= v >> 31
+	v *= 0x7fb5d329728ea185
+	v ^= v >> 27
+	v *= 0x81dadef4bc2dd44d
+	v ^= v >> 33
+	return v
+}
+
+func hash3(a, b, c uint64) uint64 {
+	h := mixBits(a + 0x9e3779b97f4a7c15)
+	h = mixBits(h ^ (b + 0x9e3779b97f4a7c15))
+	return mixBits(h ^ (c + 0x9e3779b97f4a7c15))
+}
+
+// hashFloat turns a hash into a number in [0, 1)
+func hashFloat(h uint64) float64 {
+	return float64(h>>11) / (1 << 53)
+}
+
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoiseMask []float64
+)
+
+// blueNoise returns the value of the blue noise mask for a pixel, the mask being moved around for each dimension
+func blueNoise(x, y uint, d uint64) float64 {
+	blueNoiseOnce.Do(func() {
+		blueNoiseMask = voidAndCluster(blueNoiseSize, 1.5, rand.New(rand.NewSource(1)))
+	})
+
+	offset := hash3(d, 4, 5)
+	mx := (uint64(x) + offset%blueNoiseSize) % blueNoiseSize
+	my := (uint64(y) + (offset/blueNoiseSize)%blueNoiseSize) % blueNoiseSize
+	return blueNoiseMask[my*blueNoiseSize+mx]
+}
+
+// voidAndCluster builds a tileable blue noise mask of size x size values in [0, 1),
+// by ranking the pixels in the order they fill the largest voids of a binary pattern
+// See: https://cv.ulichney.com/papers/1993-void-cluster.pdf
+func voidAndCluster(size int, sigma float64, rnd *rand.Rand) []float64 {
+	n := size * size
+
+	// Gaussian energy of a point on the pixels around, wrapping around the edges
+	kernel := make([]float64, n)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx := float64(minInt(x, size-x))
+			dy := float64(minInt(y, size-y))
+			kernel[y*size+x] = math.Exp(-(dx*dx + dy*dy) / (2 * sigma * sigma))
+		}
+	}
+
+	pattern := make([]bool, n)
+	energy := make([]float64, n)
+	update := func(i int, sign float64) {
+		px, py := i%size, i/size
+		for y := 0; y < size; y++ {
+			ky := ((y - py + size) % size) * size
+			for x := 0; x < size; x++ {
+				energy[y*size+x] += sign * kernel[ky+(x-px+size)%size]
+			}
+		}
+	}
+	set := func(i int, value bool) {
+		pattern[i] = value
+		update(i, ternaryFloat64(value, 1, -1))
+	}
+
+	// extreme returns the pixel of the pattern with the highest energy (tightest cluster) or without with the lowest (largest void)
+	extreme := func(value bool) int {
+		best := -1
+		for i := 0; i < n; i++ {
+			if pattern[i] != value {
+				continue
+			}
+			if best == -1 || (value && energy[i] > energy[best]) || (!value && energy[i] < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// Initial pattern, made evenly distributed by moving points from clusters to voids
+	ones := n / 10
+	for _, i := range rnd.Perm(n)[:ones] {
+		set(i, true)
+	}
+	for {
+		cluster := extreme(true)
+		set(cluster, false)
+		void := extreme(false)
+		set(void, true)
+		if void == cluster {
+			break
+		}
+	}
+
+	ranks := make([]int, n)
+	initial := make([]bool, n)
+	copy(initial, pattern)
+	initialEnergy := make([]float64, n)
+	copy(initialEnergy, energy)
+
+	// Points of the initial pattern, ranked by removing the tightest clusters first
+	for rank := ones - 1; rank >= 0; rank-- {
+		cluster := extreme(true)
+		set(cluster, false)
+		ranks[cluster] = rank
+	}
+
+	// The other pixels, ranked by filling the largest voids
+	copy(pattern, initial)
+	copy(energy, initialEnergy)
+	for rank := ones; rank < n; rank++ {
+		void := extreme(false)
+		set(void, true)
+		ranks[void] = rank
+	}
+
+	mask := make([]float64, n)
+	for i, rank := range ranks {
+		mask[i] = (float64(rank) + .5) / float64(n)
+	}
+	return mask
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
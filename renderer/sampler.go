@@ -2,6 +2,24 @@ package renderer
 
 import "math/rand"
 
+// Sampler computes the linear radiance of a pixel
 type Sampler interface {
 	Sample(x, y uint, camera Camera, scene Scene, options RenderingOptions, rnd *rand.Rand) Vector3
 }
+
+// FilmSampler is implemented by samplers that also contribute to other pixels than the one being sampled,
+// such as light tracing strategies. Render hands them the film they should splat onto.
+type FilmSampler interface {
+	Sampler
+	WithFilm(film *Film) Sampler
+}
+
+// PassSampler is implemented by samplers rendering the image in several passes over all the pixels, such as
+// photon mappers. BeginPass is called before each pass, once every pixel of the previous one has been sampled,
+// and returns the sampler to use for the pass. The value computed for a pixel replaces the one of previous passes.
+// Passes may return zero when the sampler makes no passes of its own, the render then being progressive.
+type PassSampler interface {
+	Sampler
+	Passes() int
+	BeginPass(pass int, camera Camera, scene Scene, options RenderingOptions) Sampler
+}
@@ -1,6 +1,109 @@
 package renderer
 
+import "math"
+
 type Scene struct {
-	Objects []Geometry
-	Lights  []Light
+	Objects    []Geometry
+	Lights     []Light
+	LightLinks []LightLink
+	Medium     Medium // Medium filling the scene, nil for vacuum
+
+	lights *lightSet
+}
+
+// prepare builds the acceleration structures needed to render the scene with the given options
+func (s Scene) prepare(options RenderingOptions) Scene {
+	s.lights = newLightSet(s, options.LightSampling)
+	return s
+}
+
+// lightSet returns the emitters of the scene, building them if the scene was not prepared
+func (s Scene) lightSet(options RenderingOptions) *lightSet {
+	if s.lights != nil {
+		return s.lights
+	}
+	return newLightSet(s, options.LightSampling)
+}
+
+// intersect returns the nearest hit along the ray and the index of the object hit, or -1.
+// Objects hidden from that kind of ray are skipped.
+func (s Scene) intersect(ray Ray, hidden Visibility) (hit Hit, index int) {
+	tnear := math.MaxFloat64
+	collisionIndex := -1
+
+	var nearestHit = Hit{Valid: false}
+
+	// Compute nearest intersection
+	for i := 0; i < len(s.Objects); i++ {
+		obj := s.Objects[i]
+		if obj.Visibility()&hidden != 0 {
+			continue
+		}
+
+		hit := obj.Intersects(ray)
+		if hit.Valid {
+			if hit.Distance < tnear {
+				tnear = hit.Distance
+				collisionIndex = i
+				nearestHit = hit
+			}
+		}
+	}
+
+	return nearestHit, collisionIndex
+}
+
+// firstSurface is like intersect but looks through the invisible boundaries of media
+func (s Scene) firstSurface(ray Ray, hidden Visibility) (Hit, int) {
+	hit, index := s.intersect(ray, hidden)
+	for index != -1 && s.Objects[index].Material().isMediumBoundary() {
+		hit, index = s.intersect(NewRay(offsetRayOrigin(hit.Position, hit.Normal, ray.Direction), ray.Direction), hidden)
+	}
+	return hit, index
+}
+
+// intersectionCounter is implemented by geometries made of several parts, which test more than one intersection
+type intersectionCounter interface {
+	intersectionTests(ray Ray) int
+}
+
+// intersectionTests returns the number of intersection tests intersect makes for the ray
+func (s Scene) intersectionTests(ray Ray, hidden Visibility) int {
+	tests := 0
+	for _, obj := range s.Objects {
+		if obj.Visibility()&hidden != 0 {
+			continue
+		}
+
+		if counter, ok := obj.(intersectionCounter); ok {
+			tests += counter.intersectionTests(ray)
+		} else {
+			tests++
+		}
+	}
+	return tests
+}
+
+// visibleBounds returns the box around the surfaces seen by the camera, slightly padded so that it is never flat
+func (s Scene) visibleBounds(camera Camera, options RenderingOptions) AABB {
+	const n = 32
+
+	bounds := EmptyAABB
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			x := (float64(i) + .5) / n * float64(options.Width)
+			y := (float64(j) + .5) / n * float64(options.Height)
+			hit, index := s.firstSurface(NewRay(camera.position, camera.rayDirection(x, y, options)), HiddenFromCamera)
+			if index != -1 {
+				bounds = bounds.Extend(hit.Position)
+			}
+		}
+	}
+
+	if bounds.Min.X > bounds.Max.X {
+		bounds = NewAABB(camera.position, camera.position)
+	}
+
+	padding := math.Max(bounds.Diagonal().Length()*.01, EPS)
+	return NewAABB(bounds.Min.Sub(NewVector3(padding, padding, padding)), bounds.Max.Add(NewVector3(padding, padding, padding)))
 }
@@ -7,6 +7,7 @@ type Sphere struct {
 	radius       float64
 	radiusSquare float64
 	material     Material
+	visibility   Visibility
 }
 
 func CreateSphere(center Vector3, radius float64, material Material) Sphere {
@@ -26,6 +27,16 @@ func (s Sphere) Material() Material {
 	return s.material
 }
 
+func (s Sphere) Visibility() Visibility {
+	return s.visibility
+}
+
+// WithVisibility returns a copy of the sphere hidden from the given kinds of rays
+func (s Sphere) WithVisibility(visibility Visibility) Sphere {
+	s.visibility = visibility
+	return s
+}
+
 func (s Sphere) Intersects(r Ray) Hit {
 	// See: https://www.scratchapixel.com/lessons/3d-basic-rendering/minimal-ray-tracer-rendering-simple-shapes/ray-sphere-intersection
 	l := s.center.Sub(r.Origin)
@@ -52,5 +63,65 @@ func (s Sphere) Intersects(r Ray) Hit {
 	pHit := r.Origin.Add(r.Direction.MulScalar(t))
 	nHit := pHit.Sub(s.center).Normalize()
 
-	return Hit{Valid: true, Distance: t, Position: pHit, Normal: nHit}
+	// Longitude and latitude, the poles being along Y
+	u := .5 + math.Atan2(nHit.X, nHit.Z)/(2*math.Pi)
+	v := safeAcos(nHit.Y) / math.Pi
+
+	return Hit{Valid: true, Distance: t, Position: pHit, Normal: nHit, U: u, V: v}
+}
+
+func (s Sphere) Radius() float64 {
+	return s.radius
+}
+
+func (s Sphere) Area() float64 {
+	return 4 * math.Pi * s.radiusSquare
+}
+
+func (s Sphere) Bounds() AABB {
+	r := NewVector3(s.radius, s.radius, s.radius)
+	return AABB{Min: s.center.Sub(r), Max: s.center.Add(r)}
+}
+
+// SampleDirection picks a direction from a point towards the sphere, uniformly inside the cone it subtends.
+// It returns the direction and its solid angle density, which is 0 when the point is inside the sphere.
+func (s Sphere) SampleDirection(from Vector3, u1, u2 float64) (Vector3, float64) {
+	// See: http://www.kevinbeason.com/smallpt/ (explicit light sampling)
+	p := s.center.Sub(from)
+	distanceSquare := p.Dot(p)
+	if distanceSquare <= s.radiusSquare {
+		return Vector3Zero, 0
+	}
+
+	// Create orthonormal coordinate frame (sw,su,sv)
+	sw := p.Normalize()
+	su := NewVector3(1, 1, 1)
+	if math.Abs(sw.X) > .1 {
+		su = NewVector3(0, 1, 0)
+	}
+	su = su.Cross(sw).Normalize()
+	sv := sw.Cross(su).Normalize()
+
+	cosAMax := math.Sqrt(1 - s.radiusSquare/distanceSquare)
+	cosA := 1 - u1 + u1*cosAMax
+	sinA := math.Sqrt(1 - cosA*cosA)
+	phi := 2 * math.Pi * u2
+
+	l1 := su.MulScalar(math.Cos(phi) * sinA)
+	l2 := sv.MulScalar(math.Sin(phi) * sinA)
+	l3 := sw.MulScalar(cosA)
+
+	omega := 2 * math.Pi * (1 - cosAMax)
+
+	return l1.Add(l2).Add(l3).Normalize(), 1 / omega
+}
+
+func (s Sphere) normalBounds() (directionCone, bool) {
+	return entireSphereCone, false
+}
+
+// SamplePoint returns a point uniformly distributed over the surface and the normal there
+func (s Sphere) SamplePoint(u1, u2 float64) (Vector3, Vector3) {
+	n := uniformSampleSphere(u1, u2)
+	return s.center.Add(n.MulScalar(s.radius)), n
 }
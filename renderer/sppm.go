@@ -0,0 +1,309 @@
+package renderer
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// SPPM is a stochastic progressive photon mapper. Each iteration shoots photons from the emitters, then traces
+// a new camera path per pixel through mirrors and glass and gathers the photons around the first diffuse point.
+// The gathering radius of every pixel shrinks over the iterations, so that the estimate converges.
+// Paths the path tracer can hardly find, such as caustics seen through a light sphere, converge much faster.
+// See: https://pbr-book.org/3ed-2018/Light_Transport_III_Bidirectional_Methods/Stochastic_Progressive_Photon_Mapping
+type SPPM struct {
+	Iterations          int     // 64 when zero
+	PhotonsPerIteration int     // Number of pixels when zero
+	InitialRadius       float64 // In scene units, 1 when zero
+	Alpha               float64 // Fraction of the new photons kept at each iteration, 2/3 when zero
+
+	state *sppmState
+}
+
+const (
+	defaultSPPMIterations = 64
+	sppmPhotonBatch       = 4096
+)
+
+type sppmState struct {
+	iteration int
+	photons   int // Photons shot per iteration
+	grid      photonGrid
+	pixels    []sppmPixel
+}
+
+// sppmPixel holds the statistics of a pixel accumulated over the iterations
+type sppmPixel struct {
+	radius float64
+	n      float64 // Accumulated number of photons
+	tau    Vector3 // Accumulated flux, scaled as the radius shrinks
+	ld     Vector3 // Sum of the radiance found directly along the camera paths
+}
+
+type photon struct {
+	point Vector3
+	wi    Vector3 // Direction the photon came from
+	beta  Vector3 // Flux carried by the photon
+}
+
+func (r SPPM) Passes() int {
+	if r.Iterations <= 0 {
+		return defaultSPPMIterations
+	}
+	return r.Iterations
+}
+
+func (r SPPM) initialRadius() float64 {
+	if r.InitialRadius <= 0 {
+		return 1
+	}
+	return r.InitialRadius
+}
+
+func (r SPPM) alpha() float64 {
+	if r.Alpha <= 0 || r.Alpha > 1 {
+		return 2. / 3.
+	}
+	return r.Alpha
+}
+
+// BeginPass shoots the photons of an iteration and stores them for the camera paths to gather
+func (r SPPM) BeginPass(pass int, camera Camera, scene Scene, options RenderingOptions) Sampler {
+	if r.state == nil {
+		photons := r.PhotonsPerIteration
+		if photons <= 0 {
+			photons = int(options.Width * options.Height)
+		}
+
+		r.state = &sppmState{photons: photons, pixels: make([]sppmPixel, options.Width*options.Height)}
+		for i := range r.state.pixels {
+			r.state.pixels[i].radius = r.initialRadius()
+		}
+	}
+
+	r.state.iteration = pass + 1
+	r.state.grid = newPhotonGrid(r.initialRadius(), r.shootPhotons(scene, options))
+
+	return r
+}
+
+// shootPhotons traces the photons of an iteration and returns those landing on diffuse surfaces after a bounce.
+// Photons reaching a surface straight from an emitter are left out, direct lighting being sampled by the camera paths.
+func (r SPPM) shootPhotons(scene Scene, options RenderingOptions) []photon {
+	lights := scene.lightSet(options)
+	if lights.totalPower == 0 {
+		return nil
+	}
+
+	// Photons are shot in batches with their own random numbers, so that they do not depend on the workers
+	batches := (r.state.photons + sppmPhotonBatch - 1) / sppmPhotonBatch
+	results := make([][]photon, batches)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func(worker int) {
+			defer wg.Done()
+
+			for batch := worker; batch < batches; batch += workers {
+				rnd := newRandom(options.Seed, uint64(r.state.iteration), uint64(batch), 0)
+				count := minInt(sppmPhotonBatch, r.state.photons-batch*sppmPhotonBatch)
+
+				for j := 0; j < count; j++ {
+					results[batch] = r.tracePhoton(scene, options, lights, rnd, results[batch])
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var photons []photon
+	for _, p := range results {
+		photons = append(photons, p...)
+	}
+
+	return photons
+}
+
+func (r SPPM) tracePhoton(scene Scene, options RenderingOptions, lights *lightSet, rnd *rand.Rand, photons []photon) []photon {
+	i, pmf := lights.alias.sample(rnd.Float64())
+	if i < 0 || pmf == 0 {
+		return photons
+	}
+
+	sample := lights.emitters[i].sampleEmission(rnd.Float64(), rnd.Float64(), rnd.Float64(), rnd.Float64())
+	if sample.pdfPos == 0 || sample.pdfDir == 0 || sample.radiance.IsZero() {
+		return photons
+	}
+
+	cos := 1.
+	if !sample.normal.IsZero() {
+		cos = math.Abs(sample.normal.Dot(sample.direction))
+	}
+	beta := sample.radiance.MulScalar(cos / (pmf * sample.pdfPos * sample.pdfDir))
+
+	ray := NewRay(offsetRayOrigin(sample.point, sample.normal, sample.direction), sample.direction)
+	hidden := HiddenFromIndirect
+
+	for depth := 0; depth < int(options.maxDepth()); depth++ {
+		hit, index := scene.intersect(ray, hidden)
+		if !hit.Valid {
+			break
+		}
+
+		// Objects the light is not linked to get none of its photons, direct or not
+		if depth == 0 && !scene.illuminates(lights.ids[i], index) {
+			break
+		}
+
+		wo := ray.Direction.MulScalar(-1)
+		b := newBSDF(scene.Objects[index].Material(), hit.Normal)
+		if !b.isDelta() && depth > 0 {
+			photons = append(photons, photon{point: hit.Position, wi: wo, beta: beta})
+		}
+
+		wi, f, pdf, delta := b.sample(wo, rnd.Float64(), rnd.Float64(), rnd.Float64())
+		if f.IsZero() || pdf == 0 {
+			break
+		}
+
+		// Russian roulette on the loss of throughput
+		next := beta.Mul(f).MulScalar(math.Abs(wi.Dot(hit.Normal)) / pdf)
+		q := math.Max(0, 1-next.Luminance()/beta.Luminance())
+		if rnd.Float64() < q {
+			break
+		}
+		beta = next.MulScalar(1 / (1 - q))
+
+		ray = NewRay(offsetRayOrigin(hit.Position, hit.Normal, wi), wi)
+		hidden = ternaryVisibility(delta, HiddenFromReflections, HiddenFromIndirect)
+	}
+
+	return photons
+}
+
+func (r SPPM) Sample(x, y uint, camera Camera, scene Scene, options RenderingOptions, rnd *rand.Rand) Vector3 {
+	if r.state == nil {
+		return Vector3Zero
+	}
+
+	// Each iteration takes the next sample of the pixel
+	samples := options.newPixelSamples(x, y, r.Passes(), rnd)
+	samples.startSample(r.state.iteration - 1)
+	rnd = rand.New(samples)
+
+	pixel := &r.state.pixels[y*options.Width+x]
+	direction := camera.rayDirection(float64(x)+rnd.Float64(), float64(y)+rnd.Float64(), options)
+	ray := NewRay(camera.position, direction)
+	hidden := HiddenFromCamera
+	beta := NewVector3(1, 1, 1)
+
+	// Follow the camera path through specular surfaces, up to the first diffuse one
+	for depth := 0; depth < int(options.maxDepth()); depth++ {
+		hit, index := scene.intersect(ray, hidden)
+		if !hit.Valid {
+			break
+		}
+
+		material := scene.Objects[index].Material()
+		wo := ray.Direction.MulScalar(-1)
+		b := newBSDF(material, hit.Normal)
+
+		pixel.ld = pixel.ld.Add(beta.Mul(material.EmissionColor))
+
+		if !b.isDelta() {
+			normal := hit.Normal
+			if normal.Dot(wo) < 0 {
+				normal = normal.MulScalar(-1)
+			}
+
+			direct := PathTracer{}.directLighting(hit.Position, normal, material.Color, index, scene, options, rnd, nil)
+			pixel.ld = pixel.ld.Add(beta.Mul(direct))
+
+			r.gather(pixel, hit.Position, wo, b, beta)
+			break
+		}
+
+		wi, f, pdf, _ := b.sample(wo, rnd.Float64(), rnd.Float64(), rnd.Float64())
+		if f.IsZero() || pdf == 0 {
+			break
+		}
+
+		beta = beta.Mul(f).MulScalar(math.Abs(wi.Dot(hit.Normal)) / pdf)
+		ray = NewRay(offsetRayOrigin(hit.Position, hit.Normal, wi), wi)
+		hidden = HiddenFromReflections
+	}
+
+	iterations := float64(r.state.iteration)
+	photons := iterations * float64(r.state.photons) * math.Pi * pixel.radius * pixel.radius
+
+	l := pixel.ld.MulScalar(1 / iterations).Add(pixel.tau.MulScalar(1 / photons))
+	return l.MulScalar(options.exposureScale())
+}
+
+// gather adds the photons found around the visible point of the pixel and shrinks its radius
+func (r SPPM) gather(pixel *sppmPixel, point, wo Vector3, b bsdf, beta Vector3) {
+	phi := Vector3Zero
+	m := 0.
+
+	r.state.grid.lookup(point, pixel.radius, func(p *photon) {
+		phi = phi.Add(b.eval(wo, p.wi).Mul(p.beta))
+		m++
+	})
+
+	if m == 0 {
+		return
+	}
+
+	n := pixel.n + r.alpha()*m
+	radius := pixel.radius * math.Sqrt(n/(pixel.n+m))
+	shrink := radius * radius / (pixel.radius * pixel.radius)
+
+	pixel.tau = pixel.tau.Add(beta.Mul(phi)).MulScalar(shrink)
+	pixel.n = n
+	pixel.radius = radius
+}
+
+// photonGrid is a hash grid of photons, with cells as large as the largest gathering radius
+type photonGrid struct {
+	cellSize float64
+	cells    map[[3]int][]photon
+}
+
+func newPhotonGrid(cellSize float64, photons []photon) photonGrid {
+	g := photonGrid{cellSize: cellSize, cells: make(map[[3]int][]photon)}
+	for _, p := range photons {
+		cell := g.cell(p.point)
+		g.cells[cell] = append(g.cells[cell], p)
+	}
+	return g
+}
+
+func (g photonGrid) cell(p Vector3) [3]int {
+	return [3]int{
+		int(math.Floor(p.X / g.cellSize)),
+		int(math.Floor(p.Y / g.cellSize)),
+		int(math.Floor(p.Z / g.cellSize)),
+	}
+}
+
+// lookup calls f for every photon closer to p than radius
+func (g photonGrid) lookup(p Vector3, radius float64, f func(*photon)) {
+	offset := NewVector3(radius, radius, radius)
+	low := g.cell(p.Sub(offset))
+	high := g.cell(p.Add(offset))
+
+	for x := low[0]; x <= high[0]; x++ {
+		for y := low[1]; y <= high[1]; y++ {
+			for z := low[2]; z <= high[2]; z++ {
+				photons := g.cells[[3]int{x, y, z}]
+				for i := range photons {
+					if photons[i].point.Sub(p).LengthSquared() <= radius*radius {
+						f(&photons[i])
+					}
+				}
+			}
+		}
+	}
+}
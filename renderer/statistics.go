@@ -0,0 +1,91 @@
+package renderer
+
+import (
+	"math"
+	"sort"
+)
+
+// sampleStatistics keeps the mean and variance of samples as they come
+// See: https://en.wikipedia.org/wiki/Algorithms_for_calculating_variance#Welford's_online_algorithm
+type sampleStatistics struct {
+	count int
+	mean  float64
+	m2    float64 // Sum of the squared differences to the mean
+}
+
+func (s *sampleStatistics) add(v float64) {
+	s.count++
+	delta := v - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (v - s.mean)
+}
+
+func (s sampleStatistics) variance() float64 {
+	if s.count < 2 {
+		return 0
+	}
+	return s.m2 / float64(s.count-1)
+}
+
+// standardError returns the standard deviation of the mean of the samples
+func (s sampleStatistics) standardError() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return math.Sqrt(s.variance() / float64(s.count))
+}
+
+// pixelEstimate combines the samples of a pixel, with the mean or the median of means
+type pixelEstimate struct {
+	sums   []Vector3 // Of each group
+	counts []int
+	next   int // Group of the next sample
+}
+
+func newPixelEstimate(groups int) *pixelEstimate {
+	return &pixelEstimate{sums: make([]Vector3, groups), counts: make([]int, groups)}
+}
+
+// add deals the samples to the groups in turn, so that each group covers the strata evenly
+func (e *pixelEstimate) add(c Vector3) {
+	e.sums[e.next] = e.sums[e.next].Add(c)
+	e.counts[e.next]++
+	e.next = (e.next + 1) % len(e.sums)
+}
+
+func (e *pixelEstimate) mean() Vector3 {
+	sum := Vector3Zero
+	count := 0
+	for i := range e.sums {
+		sum = sum.Add(e.sums[i])
+		count += e.counts[i]
+	}
+	return sum.MulScalar(1 / math.Max(1, float64(count)))
+}
+
+// medianOfMeans returns the mean of the group with the median luminance
+func (e *pixelEstimate) medianOfMeans() Vector3 {
+	var means []Vector3
+	for i := range e.sums {
+		if e.counts[i] > 0 {
+			means = append(means, e.sums[i].MulScalar(1/float64(e.counts[i])))
+		}
+	}
+	return medianLuminance(means)
+}
+
+// medianLuminance returns the color with the median luminance, or the average of the two middle ones
+// when their number is even
+func medianLuminance(colors []Vector3) Vector3 {
+	if len(colors) == 0 {
+		return Vector3Zero
+	}
+
+	sort.Slice(colors, func(i, j int) bool { return colors[i].Luminance() < colors[j].Luminance() })
+
+	middle := len(colors) / 2
+	if len(colors)%2 == 0 {
+		return colors[middle-1].Add(colors[middle]).MulScalar(.5)
+	}
+	return colors[middle]
+}
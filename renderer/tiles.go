@@ -0,0 +1,117 @@
+package renderer
+
+import (
+	"math"
+	"sort"
+)
+
+// TileOrder selects the order in which the tiles of the image are handed to the workers
+type TileOrder uint
+
+const (
+	// TileScanline goes row by row, from the top left corner
+	TileScanline TileOrder = iota
+	// TileSpiral starts from the center of the image, where the subject usually is, and spirals outwards
+	TileSpiral
+	// TileHilbert follows a Hilbert curve, so that consecutive tiles are close to each other
+	TileHilbert
+)
+
+const defaultTileSize = 32
+
+// tile is a rectangle of pixels rendered by a worker at once, the colors of its pixels being stored row by row
+type tile struct {
+	x0, y0 uint // Inclusive
+	x1, y1 uint // Exclusive
+	index  int  // Position in the order of the tiles
+	colors []Vector3
+	film   *Film // Samples of the tile, with a reconstruction filter
+}
+
+func (t tile) pixels() uint {
+	return (t.x1 - t.x0) * (t.y1 - t.y0)
+}
+
+func (o RenderingOptions) tileSize() uint {
+	if o.TileSize == 0 {
+		return defaultTileSize
+	}
+	return o.TileSize
+}
+
+// tiles splits the image into tiles, in the order of the options
+func (o RenderingOptions) tiles() []tile {
+	size := o.tileSize()
+	columns := (o.Width + size - 1) / size
+	rows := (o.Height + size - 1) / size
+
+	tiles := make([]tile, 0, columns*rows)
+	for ty := uint(0); ty < rows; ty++ {
+		for tx := uint(0); tx < columns; tx++ {
+			tiles = append(tiles, tile{
+				x0: tx * size,
+				y0: ty * size,
+				x1: uint(math.Min(float64((tx+1)*size), float64(o.Width))),
+				y1: uint(math.Min(float64((ty+1)*size), float64(o.Height))),
+			})
+		}
+	}
+
+	switch o.TileOrder {
+	case TileSpiral:
+		// By ring around the central tile, then by angle
+		cx, cy := float64(columns-1)/2, float64(rows-1)/2
+		key := func(t tile) (float64, float64) {
+			dx := float64(t.x0/size) - cx
+			dy := float64(t.y0/size) - cy
+			return math.Max(math.Abs(dx), math.Abs(dy)), math.Atan2(dy, dx)
+		}
+		sort.SliceStable(tiles, func(i, j int) bool {
+			ri, ai := key(tiles[i])
+			rj, aj := key(tiles[j])
+			if ri != rj {
+				return ri < rj
+			}
+			return ai < aj
+		})
+	case TileHilbert:
+		n := uint(1)
+		for n < columns || n < rows {
+			n *= 2
+		}
+		sort.SliceStable(tiles, func(i, j int) bool {
+			return hilbertIndex(n, tiles[i].x0/size, tiles[i].y0/size) < hilbertIndex(n, tiles[j].x0/size, tiles[j].y0/size)
+		})
+	}
+
+	for i := range tiles {
+		tiles[i].index = i
+	}
+	return tiles
+}
+
+// hilbertIndex returns the position of a cell along the Hilbert curve covering a n x n grid, n being a power of two
+// See: https://en.wikipedia.org/wiki/Hilbert_curve
+func hilbertIndex(n, x, y uint) uint {
+	d := uint(0)
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+
+		// Rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
@@ -0,0 +1,232 @@
+package renderer
+
+import "math"
+
+type Triangle struct {
+	v0, v1, v2 Vector3
+	normal     Vector3
+	normals    [3]Vector3 // Vertex normals, zero when the triangle is flat shaded
+	area       float64
+	material   Material
+	visibility Visibility
+}
+
+func CreateTriangle(v0, v1, v2 Vector3, material Material) Triangle {
+	cross := v1.Sub(v0).Cross(v2.Sub(v0))
+
+	return Triangle{
+		v0:       v0,
+		v1:       v1,
+		v2:       v2,
+		normal:   cross.Normalize(),
+		area:     cross.Length() / 2,
+		material: material,
+	}
+}
+
+func (t Triangle) Position() Vector3 {
+	return t.v0.Add(t.v1).Add(t.v2).MulScalar(1. / 3.)
+}
+
+func (t Triangle) Material() Material {
+	return t.material
+}
+
+func (t Triangle) Visibility() Visibility {
+	return t.visibility
+}
+
+// WithVisibility returns a copy of the triangle hidden from the given kinds of rays
+func (t Triangle) WithVisibility(visibility Visibility) Triangle {
+	t.visibility = visibility
+	return t
+}
+
+// WithNormals returns a copy of the triangle whose shading normal is interpolated between the given vertex normals
+func (t Triangle) WithNormals(n0, n1, n2 Vector3) Triangle {
+	t.normals = [3]Vector3{n0.Normalize(), n1.Normalize(), n2.Normalize()}
+	return t
+}
+
+func (t Triangle) Normal() Vector3 {
+	return t.normal
+}
+
+func (t Triangle) Area() float64 {
+	return t.area
+}
+
+func (t Triangle) Bounds() AABB {
+	return NewAABB(t.v0, t.v1).Extend(t.v2)
+}
+
+func (t Triangle) Intersects(r Ray) Hit {
+	// See: https://www.scratchapixel.com/lessons/3d-basic-rendering/ray-tracing-rendering-a-triangle/moller-trumbore-ray-triangle-intersection
+	edge1 := t.v1.Sub(t.v0)
+	edge2 := t.v2.Sub(t.v0)
+
+	pvec := r.Direction.Cross(edge2)
+	det := edge1.Dot(pvec)
+	if math.Abs(det) < EPS {
+		return NoHit
+	}
+	invDet := 1 / det
+
+	tvec := r.Origin.Sub(t.v0)
+	u := tvec.Dot(pvec) * invDet
+	if u < 0 || u > 1 {
+		return NoHit
+	}
+
+	qvec := tvec.Cross(edge1)
+	v := r.Direction.Dot(qvec) * invDet
+	if v < 0 || u+v > 1 {
+		return NoHit
+	}
+
+	distance := edge2.Dot(qvec) * invDet
+	if distance <= EPS {
+		return NoHit
+	}
+
+	shadingNormal := Vector3Zero
+	if !t.normals[0].IsZero() {
+		shadingNormal = t.normals[0].MulScalar(1 - u - v).Add(t.normals[1].MulScalar(u)).Add(t.normals[2].MulScalar(v)).Normalize()
+	}
+
+	return Hit{
+		Valid:         true,
+		Distance:      distance,
+		Position:      r.Origin.Add(r.Direction.MulScalar(distance)),
+		Normal:        t.normal,
+		ShadingNormal: shadingNormal,
+		U:             u,
+		V:             v,
+	}
+}
+
+// Spherical triangle sampling is numerically unstable for very small and very large solid angles,
+// triangles outside this range are sampled by area instead.
+const (
+	minSphericalSampleArea = 3e-4
+	maxSphericalSampleArea = 6.22
+)
+
+// SampleDirection picks a direction from a point towards the triangle, either uniformly over its
+// area or uniformly inside the solid angle it subtends. It returns the direction and its solid angle density.
+func (t Triangle) SampleDirection(from Vector3, u1, u2 float64) (Vector3, float64) {
+	if solidAngle := t.solidAngle(from); solidAngle > minSphericalSampleArea && solidAngle < maxSphericalSampleArea {
+		return t.sampleSolidAngle(from, u1, u2)
+	}
+
+	return t.sampleArea(from, u1, u2)
+}
+
+// SamplePoint returns a point uniformly distributed over the surface and the normal there
+func (t Triangle) SamplePoint(u1, u2 float64) (Vector3, Vector3) {
+	// Uniform barycentric coordinates
+	su := math.Sqrt(u1)
+	b0 := 1 - su
+	b1 := u2 * su
+
+	return t.v0.MulScalar(b0).Add(t.v1.MulScalar(b1)).Add(t.v2.MulScalar(1 - b0 - b1)), t.normal
+}
+
+func (t Triangle) sampleArea(from Vector3, u1, u2 float64) (Vector3, float64) {
+	p, _ := t.SamplePoint(u1, u2)
+
+	toLight := p.Sub(from)
+	distanceSquare := toLight.LengthSquared()
+	if distanceSquare == 0 {
+		return Vector3Zero, 0
+	}
+	direction := toLight.Normalize()
+
+	// Convert the area density into a solid angle density
+	cos := math.Abs(t.normal.Dot(direction))
+	if cos == 0 {
+		return Vector3Zero, 0
+	}
+
+	return direction, distanceSquare / (cos * t.area)
+}
+
+// sphericalTriangle returns the vertices of the triangle projected on the unit sphere around p,
+// and the angles of the spherical triangle they form
+func (t Triangle) sphericalTriangle(p Vector3) (a, b, c Vector3, alpha, beta, gamma float64, ok bool) {
+	a = t.v0.Sub(p).Normalize()
+	b = t.v1.Sub(p).Normalize()
+	c = t.v2.Sub(p).Normalize()
+
+	nab := a.Cross(b)
+	nbc := b.Cross(c)
+	nca := c.Cross(a)
+	if nab.IsZero() || nbc.IsZero() || nca.IsZero() {
+		return a, b, c, 0, 0, 0, false
+	}
+	nab = nab.Normalize()
+	nbc = nbc.Normalize()
+	nca = nca.Normalize()
+
+	alpha = safeAcos(nab.Dot(nca.MulScalar(-1)))
+	beta = safeAcos(nbc.Dot(nab.MulScalar(-1)))
+	gamma = safeAcos(nca.Dot(nbc.MulScalar(-1)))
+
+	return a, b, c, alpha, beta, gamma, true
+}
+
+func (t Triangle) solidAngle(p Vector3) float64 {
+	_, _, _, alpha, beta, gamma, ok := t.sphericalTriangle(p)
+	if !ok {
+		return 0
+	}
+	return math.Max(0, alpha+beta+gamma-math.Pi)
+}
+
+// sampleSolidAngle uniformly samples the spherical triangle
+// See: Arvo, "Stratified Sampling of Spherical Triangles" and https://pbr-book.org/4ed/Sampling_Algorithms/Sampling_Multidimensional_Functions#SphericalTriangleSampling
+func (t Triangle) sampleSolidAngle(p Vector3, u1, u2 float64) (Vector3, float64) {
+	a, b, c, alpha, beta, gamma, ok := t.sphericalTriangle(p)
+	if !ok {
+		return Vector3Zero, 0
+	}
+
+	area := alpha + beta + gamma - math.Pi
+	if area <= 0 {
+		return Vector3Zero, 0
+	}
+
+	// Pick the sub-triangle area, then the point on the edge (a, c') it defines
+	areaPi := math.Pi + u1*area
+	cosAlpha := math.Cos(alpha)
+	sinAlpha := math.Sin(alpha)
+
+	sinPhi := math.Sin(areaPi)*cosAlpha - math.Cos(areaPi)*sinAlpha
+	cosPhi := math.Cos(areaPi)*cosAlpha + math.Sin(areaPi)*sinAlpha
+
+	k1 := cosPhi + cosAlpha
+	k2 := sinPhi - sinAlpha*a.Dot(b)
+	cosB := (k2 + (k2*cosPhi-k1*sinPhi)*cosAlpha) / ((k2*sinPhi + k1*cosPhi) * sinAlpha)
+	cosB = math.Max(-1, math.Min(1, cosB))
+	sinB := safeSqrt(1 - cosB*cosB)
+
+	cp := a.MulScalar(cosB).Add(gramSchmidt(c, a).Normalize().MulScalar(sinB))
+
+	// Then a point along the arc between b and c'
+	cosTheta := 1 - u2*(1-cp.Dot(b))
+	sinTheta := safeSqrt(1 - cosTheta*cosTheta)
+
+	direction := b.MulScalar(cosTheta).Add(gramSchmidt(cp, b).Normalize().MulScalar(sinTheta))
+
+	return direction.Normalize(), 1 / area
+}
+
+// gramSchmidt removes the component of v along the normalized vector w
+func gramSchmidt(v, w Vector3) Vector3 {
+	return v.Sub(w.MulScalar(v.Dot(w)))
+}
+
+func (t Triangle) normalBounds() (directionCone, bool) {
+	// Emissive triangles emit on both sides
+	return directionCone{w: t.normal, cosTheta: 1}, true
+}
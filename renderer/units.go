@@ -0,0 +1,194 @@
+package renderer
+
+import "math"
+
+// LightUnit is the unit in which the intensity of an Emission is expressed
+type LightUnit uint
+
+const (
+	// Nits is luminance (cd/m²), for emissive surfaces
+	Nits LightUnit = iota
+	// Candela is luminous intensity, for point, spot and IES lights
+	Candela
+	// Lumens is luminous flux, the total visible power emitted
+	Lumens
+	// Watts is radiant flux. It is converted with the luminous efficacy of the blackbody spectrum
+	// when a temperature is given, and with the maximum efficacy of 683 lm/W otherwise.
+	Watts
+)
+
+const maxLuminousEfficacy = 683.
+
+// Emission describes the light emitted by a light or a surface in physical terms, independently of the scene
+type Emission struct {
+	Color       Vector3 // Only the chromaticity is used, white when zero
+	Temperature float64 // Blackbody temperature in Kelvin, used instead of Color when set
+	Intensity   float64
+	Unit        LightUnit
+}
+
+// color returns the chromaticity of the emission, normalized to a luminance of 1
+func (e Emission) color() Vector3 {
+	if e.Temperature > 0 {
+		return Blackbody(e.Temperature)
+	}
+
+	if luminance := e.Color.Luminance(); luminance > 0 {
+		return e.Color.MulScalar(1 / luminance)
+	}
+
+	return NewVector3(1, 1, 1)
+}
+
+// lumens converts a flux into lumens
+func (e Emission) lumens() float64 {
+	if e.Unit != Watts {
+		return e.Intensity
+	}
+
+	if e.Temperature > 0 {
+		return e.Intensity * blackbodyEfficacy(e.Temperature)
+	}
+
+	return e.Intensity * maxLuminousEfficacy
+}
+
+// PhysicalUnits converts physical light quantities into the radiance used by the renderer,
+// where 1 is a luminance of one nit. Reusing the same Emission values in scenes modeled at
+// different scales only requires changing MetersPerUnit.
+type PhysicalUnits struct {
+	MetersPerUnit float64 // Size of one scene unit, 1 when zero
+}
+
+func (u PhysicalUnits) metersPerUnit() float64 {
+	if u.MetersPerUnit <= 0 {
+		return 1
+	}
+	return u.MetersPerUnit
+}
+
+// Emissive returns a copy of the material emitting as described. The area of the geometry, in scene units,
+// is needed when the emission is given as a flux; it is spread over one side of the surface.
+func (u PhysicalUnits) Emissive(material Material, emission Emission, area float64) Material {
+	radiance := emission.Intensity
+
+	switch emission.Unit {
+	case Lumens, Watts:
+		// Lambertian emitter: flux = PI * area * radiance
+		squareMeters := area * u.metersPerUnit() * u.metersPerUnit()
+		if squareMeters <= 0 {
+			radiance = 0
+		} else {
+			radiance = emission.lumens() / (math.Pi * squareMeters)
+		}
+	case Candela:
+		// Intensity spread over the projected area of the surface
+		squareMeters := area * u.metersPerUnit() * u.metersPerUnit()
+		if squareMeters <= 0 {
+			radiance = 0
+		} else {
+			radiance = emission.Intensity / squareMeters
+		}
+	}
+
+	material.EmissionColor = emission.color().MulScalar(radiance)
+	return material
+}
+
+// Light returns a copy of the light emitting as described, accounting for its spot cone or profile
+func (u PhysicalUnits) Light(light Light, emission Emission) Light {
+	// Unit luminance, so that Power returns the flux of a light of one candela
+	light.EmissionColor = emission.color()
+
+	candela := emission.Intensity
+	switch emission.Unit {
+	case Lumens, Watts:
+		if flux := light.Power(); flux > 0 {
+			candela = emission.lumens() / flux
+		} else {
+			candela = 0
+		}
+	case Nits:
+		// A point has no area, nits are treated as candela
+	}
+
+	// Distances are measured in scene units, the inverse square falloff must be scaled accordingly
+	scale := 1 / (u.metersPerUnit() * u.metersPerUnit())
+
+	light.EmissionColor = light.EmissionColor.MulScalar(candela * scale)
+	return light
+}
+
+// Blackbody returns the linear RGB color of a blackbody at the given temperature in Kelvin, with a luminance of 1
+func Blackbody(kelvin float64) Vector3 {
+	x, y, z := 0., 0., 0.
+	for lambda := 360.; lambda <= 830; lambda++ {
+		b := planck(lambda, kelvin)
+		x += b * cieX(lambda)
+		y += b * cieY(lambda)
+		z += b * cieZ(lambda)
+	}
+
+	if y == 0 {
+		return Vector3Zero
+	}
+
+	// XYZ to linear sRGB, normalized so that Y = 1
+	x, z = x/y, z/y
+	y = 1
+	rgb := NewVector3(
+		3.2406*x-1.5372*y-0.4986*z,
+		-0.9689*x+1.8758*y+0.0415*z,
+		0.0557*x-0.2040*y+1.0570*z,
+	).Max(Vector3Zero)
+
+	return rgb.MulScalar(1 / rgb.Luminance())
+}
+
+// planck returns the spectral radiance of a blackbody, in W/(sr·m²·m), for a wavelength in nanometers
+func planck(lambda, kelvin float64) float64 {
+	const c = 299792458.
+	const h = 6.62606957e-34
+	const kb = 1.3806488e-23
+
+	l := lambda * 1e-9
+	return 2 * h * c * c / (math.Pow(l, 5) * (math.Exp(h*c/(l*kb*kelvin)) - 1))
+}
+
+// blackbodyEfficacy returns the luminous efficacy of a blackbody spectrum in lm/W
+func blackbodyEfficacy(kelvin float64) float64 {
+	const stefanBoltzmann = 5.670374419e-8
+
+	luminance := 0.
+	for lambda := 360.; lambda <= 830; lambda++ {
+		luminance += planck(lambda, kelvin) * cieY(lambda) * 1e-9
+	}
+
+	// Total radiance over all wavelengths
+	radiance := stefanBoltzmann * math.Pow(kelvin, 4) / math.Pi
+
+	return maxLuminousEfficacy * luminance / radiance
+}
+
+// CIE 1931 color matching functions, multi-lobe fit
+// See: Wyman, Sloan and Shirley, "Simple Analytic Approximations to the CIE XYZ Color Matching Functions"
+func cieX(lambda float64) float64 {
+	return 1.056*lobe(lambda, 599.8, 37.9, 31.0) + 0.362*lobe(lambda, 442.0, 16.0, 26.7) - 0.065*lobe(lambda, 501.1, 20.4, 26.2)
+}
+
+func cieY(lambda float64) float64 {
+	return 0.821*lobe(lambda, 568.8, 46.9, 40.5) + 0.286*lobe(lambda, 530.9, 16.3, 31.1)
+}
+
+func cieZ(lambda float64) float64 {
+	return 1.217*lobe(lambda, 437.0, 11.8, 36.0) + 0.681*lobe(lambda, 459.0, 26.0, 13.8)
+}
+
+func lobe(x, mean, sigmaLow, sigmaHigh float64) float64 {
+	sigma := sigmaHigh
+	if x < mean {
+		sigma = sigmaLow
+	}
+	t := (x - mean) / sigma
+	return math.Exp(-.5 * t * t)
+}
@@ -11,3 +11,43 @@ func degToRad(angle float64) float64 {
 func radToDeg(angle float64) float64 {
 	return angle * 180. / math.Pi
 }
+
+func safeSqrt(x float64) float64 {
+	return math.Sqrt(math.Max(0, x))
+}
+
+func safeAcos(x float64) float64 {
+	return math.Acos(math.Max(-1, math.Min(1, x)))
+}
+
+// rotate applies a rotation of the given angle around a normalized axis (Rodrigues' formula)
+func rotate(v, axis Vector3, angle float64) Vector3 {
+	cos := math.Cos(angle)
+	sin := math.Sin(angle)
+	return v.MulScalar(cos).
+		Add(axis.Cross(v).MulScalar(sin)).
+		Add(axis.MulScalar(axis.Dot(v) * (1 - cos)))
+}
+
+// smoothStep is 0 below a, 1 above b and smoothly interpolated in between
+func smoothStep(x, a, b float64) float64 {
+	if a == b {
+		if x < a {
+			return 0
+		}
+		return 1
+	}
+
+	t := math.Max(0, math.Min(1, (x-a)/(b-a)))
+	return t * t * (3 - 2*t)
+}
+
+// coordinateSystem returns two unit vectors forming an orthonormal basis with the normalized vector w
+func coordinateSystem(w Vector3) (Vector3, Vector3) {
+	u := NewVector3(1, 1, 1)
+	if math.Abs(w.X) > .1 {
+		u = NewVector3(0, 1, 0)
+	}
+	u = u.Cross(w).Normalize()
+	return u, w.Cross(u).Normalize()
+}
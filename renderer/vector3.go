@@ -74,3 +74,32 @@ func (v Vector3) Normalize() Vector3 {
 }
 
 var Vector3Zero Vector3 = NewVector3(0, 0, 0)
+
+func (v Vector3) Min(v2 Vector3) Vector3 {
+	return Vector3{X: math.Min(v.X, v2.X), Y: math.Min(v.Y, v2.Y), Z: math.Min(v.Z, v2.Z)}
+}
+
+func (v Vector3) Max(v2 Vector3) Vector3 {
+	return Vector3{X: math.Max(v.X, v2.X), Y: math.Max(v.Y, v2.Y), Z: math.Max(v.Z, v2.Z)}
+}
+
+// MaxComponent returns the largest of X, Y and Z
+func (v Vector3) MaxComponent() float64 {
+	return math.Max(v.X, math.Max(v.Y, v.Z))
+}
+
+// Axis returns X, Y or Z for 0, 1 or 2
+func (v Vector3) Axis(axis int) float64 {
+	switch axis {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
+
+// Luminance treats the vector as a linear RGB color (Rec. 709 primaries)
+func (v Vector3) Luminance() float64 {
+	return 0.2126*v.X + 0.7152*v.Y + 0.0722*v.Z
+}
@@ -0,0 +1,70 @@
+package renderer
+
+// Visibility hides a geometry from some kinds of rays. The zero value is visible to all of them.
+type Visibility uint8
+
+const (
+	// HiddenFromCamera objects are not seen by primary rays
+	HiddenFromCamera Visibility = 1 << iota
+	// HiddenFromShadows objects do not block light
+	HiddenFromShadows
+	// HiddenFromReflections objects are not seen through mirrors and glass
+	HiddenFromReflections
+	// HiddenFromIndirect objects are not seen by diffuse bounces
+	HiddenFromIndirect
+)
+
+// LightID refers to a light of a scene, either an emissive object or one of Scene.Lights
+type LightID struct {
+	index  int
+	object bool
+}
+
+// EmissiveObject refers to the emissive object at the given index of Scene.Objects
+func EmissiveObject(index int) LightID {
+	return LightID{index: index, object: true}
+}
+
+// SceneLight refers to the light at the given index of Scene.Lights
+func SceneLight(index int) LightID {
+	return LightID{index: index}
+}
+
+// LightLink restricts the objects a light illuminates directly. Objects are indices in Scene.Objects.
+// Once a light has an including link, it only lights the objects listed by its including links.
+type LightLink struct {
+	Light   LightID
+	Objects []int
+	Exclude bool
+}
+
+// illuminates tells whether a light contributes direct lighting to an object, according to the light links
+func (s Scene) illuminates(light LightID, object int) bool {
+	restricted := false
+	included := false
+
+	for _, link := range s.LightLinks {
+		if link.Light != light {
+			continue
+		}
+
+		listed := false
+		for _, o := range link.Objects {
+			if o == object {
+				listed = true
+				break
+			}
+		}
+
+		if link.Exclude {
+			if listed {
+				return false
+			}
+		} else {
+			restricted = true
+			included = included || listed
+		}
+	}
+
+	return !restricted || included
+}
@@ -0,0 +1,402 @@
+package renderer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+)
+
+// VoxelGrid is a dense grid of densities, optionally along with emission values.
+// Values are located at the center of the voxels and trilinearly interpolated in between.
+type VoxelGrid struct {
+	nx, ny, nz int
+	density    []float64
+	emission   []float64 // Nil when the grid does not emit
+}
+
+func NewVoxelGrid(nx, ny, nz int) *VoxelGrid {
+	return &VoxelGrid{nx: nx, ny: ny, nz: nz, density: make([]float64, nx*ny*nz)}
+}
+
+func (g *VoxelGrid) Resolution() (int, int, int) {
+	return g.nx, g.ny, g.nz
+}
+
+func (g *VoxelGrid) index(x, y, z int) int {
+	return (z*g.ny+y)*g.nx + x
+}
+
+func (g *VoxelGrid) SetDensity(x, y, z int, density float64) {
+	g.density[g.index(x, y, z)] = density
+}
+
+func (g *VoxelGrid) SetEmission(x, y, z int, emission float64) {
+	if g.emission == nil {
+		g.emission = make([]float64, len(g.density))
+	}
+	g.emission[g.index(x, y, z)] = emission
+}
+
+const voxelGridMagic = "VXGR"
+
+// Limits of the grids read from files, their header being trusted with nothing more
+const (
+	maxVoxelGridResolution = 4096
+	maxVoxelGridVoxels     = 1 << 27
+)
+
+// LoadVoxelGrid reads a voxel grid file, see ParseVoxelGrid for the format
+func LoadVoxelGrid(path string) (*VoxelGrid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseVoxelGrid(bufio.NewReader(f))
+}
+
+// ParseVoxelGrid reads a voxel grid from a little endian binary stream made of:
+//   - the 4 bytes "VXGR"
+//   - the resolution along x, y and z, as three uint32
+//   - the number of channels as a uint32: 1 for density only, 2 for density and emission
+//   - the voxels as float32, x varying the fastest then y then z, the channels of a voxel being consecutive
+func ParseVoxelGrid(r io.Reader) (*VoxelGrid, error) {
+	magic := make([]byte, len(voxelGridMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != voxelGridMagic {
+		return nil, fmt.Errorf("voxel grid: bad magic %q", magic)
+	}
+
+	var header [4]uint32
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	nx, ny, nz, channels := int(header[0]), int(header[1]), int(header[2]), int(header[3])
+	if nx == 0 || ny == 0 || nz == 0 {
+		return nil, fmt.Errorf("voxel grid: empty resolution %dx%dx%d", nx, ny, nz)
+	}
+	// Each dimension is bounded first so that their product cannot overflow
+	if nx > maxVoxelGridResolution || ny > maxVoxelGridResolution || nz > maxVoxelGridResolution ||
+		uint64(nx)*uint64(ny)*uint64(nz) > maxVoxelGridVoxels {
+		return nil, fmt.Errorf("voxel grid: resolution %dx%dx%d too large", nx, ny, nz)
+	}
+	if channels != 1 && channels != 2 {
+		return nil, fmt.Errorf("voxel grid: unsupported number of channels %d", channels)
+	}
+
+	g := NewVoxelGrid(nx, ny, nz)
+	if channels == 2 {
+		g.emission = make([]float64, len(g.density))
+	}
+
+	// Row by row, rather than through a copy of the whole grid
+	row := make([]float32, nx*channels)
+	for i := 0; i < len(g.density); i += nx {
+		if err := binary.Read(r, binary.LittleEndian, row); err != nil {
+			return nil, fmt.Errorf("voxel grid: reading row %d of %d: %w", i/nx, ny*nz, err)
+		}
+		for x := 0; x < nx; x++ {
+			g.density[i+x] = float64(row[x*channels])
+			if channels == 2 {
+				g.emission[i+x] = float64(row[x*channels+1])
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// lookup interpolates the values of a channel at a point of the unit cube, voxels outside of the grid being zero
+func (g *VoxelGrid) lookup(values []float64, p Vector3) float64 {
+	x := p.X*float64(g.nx) - .5
+	y := p.Y*float64(g.ny) - .5
+	z := p.Z*float64(g.nz) - .5
+
+	x0, y0, z0 := int(math.Floor(x)), int(math.Floor(y)), int(math.Floor(z))
+	dx, dy, dz := x-float64(x0), y-float64(y0), z-float64(z0)
+
+	voxel := func(x, y, z int) float64 {
+		if x < 0 || y < 0 || z < 0 || x >= g.nx || y >= g.ny || z >= g.nz {
+			return 0
+		}
+		return values[g.index(x, y, z)]
+	}
+	lerp := func(t, a, b float64) float64 {
+		return a + t*(b-a)
+	}
+
+	d00 := lerp(dx, voxel(x0, y0, z0), voxel(x0+1, y0, z0))
+	d10 := lerp(dx, voxel(x0, y0+1, z0), voxel(x0+1, y0+1, z0))
+	d01 := lerp(dx, voxel(x0, y0, z0+1), voxel(x0+1, y0, z0+1))
+	d11 := lerp(dx, voxel(x0, y0+1, z0+1), voxel(x0+1, y0+1, z0+1))
+
+	return lerp(dz, lerp(dy, d00, d10), lerp(dy, d01, d11))
+}
+
+// GridMedium is a heterogeneous medium whose density is read from a voxel grid stretched over a box.
+// It is rendered with delta tracking and ratio tracking, the tentative collisions being sampled against
+// the maximum density of the cells of a coarse majorant grid.
+// See: https://pbr-book.org/4ed/Volume_Scattering/Volume_Scattering_Processes#SamplingtheMajorantTransmittance
+type GridMedium struct {
+	Grid       *VoxelGrid
+	Bounds     AABB
+	Absorption Vector3 // Per unit of density and scene unit
+	Scattering Vector3 // Per unit of density and scene unit
+	G          float64 // Asymmetry of the Henyey-Greenstein phase function
+	Emission   Vector3 // Radiance emitted per unit of the emission channel, by the absorbing part of the medium
+
+	majorants *majorantGrid
+}
+
+// majorantGrid holds the maximum density over regions of the voxel grid
+type majorantGrid struct {
+	resolution int
+	values     []float64
+}
+
+const majorantGridResolution = 16
+
+func NewGridMedium(grid *VoxelGrid, bounds AABB, absorption, scattering Vector3, g float64, emission Vector3) GridMedium {
+	m := GridMedium{Grid: grid, Bounds: bounds, Absorption: absorption, Scattering: scattering, G: g, Emission: emission}
+	m.majorants = newMajorantGrid(grid, majorantGridResolution)
+	return m
+}
+
+func newMajorantGrid(grid *VoxelGrid, resolution int) *majorantGrid {
+	m := &majorantGrid{resolution: resolution, values: make([]float64, resolution*resolution*resolution)}
+
+	// Voxels whose interpolation footprint overlaps [lo, hi] in the unit cube
+	voxelRange := func(lo, hi float64, n int) (int, int) {
+		first := int(math.Floor(lo*float64(n) - .5))
+		last := int(math.Ceil(hi*float64(n) - .5))
+		return int(math.Max(0, float64(first))), int(math.Min(float64(n-1), float64(last)))
+	}
+
+	r := float64(resolution)
+	for z := 0; z < resolution; z++ {
+		z0, z1 := voxelRange(float64(z)/r, float64(z+1)/r, grid.nz)
+		for y := 0; y < resolution; y++ {
+			y0, y1 := voxelRange(float64(y)/r, float64(y+1)/r, grid.ny)
+			for x := 0; x < resolution; x++ {
+				x0, x1 := voxelRange(float64(x)/r, float64(x+1)/r, grid.nx)
+
+				max := 0.
+				for vz := z0; vz <= z1; vz++ {
+					for vy := y0; vy <= y1; vy++ {
+						for vx := x0; vx <= x1; vx++ {
+							max = math.Max(max, grid.density[grid.index(vx, vy, vz)])
+						}
+					}
+				}
+				m.values[(z*resolution+y)*resolution+x] = max
+			}
+		}
+	}
+
+	return m
+}
+
+// Proxy returns the box to add to the scene for the medium to be rendered
+func (m GridMedium) Proxy() Box {
+	return CreateBox(m.Bounds.Min, m.Bounds.Max, NewMediumBoundary(m))
+}
+
+func (m GridMedium) local(p Vector3) Vector3 {
+	return p.Sub(m.Bounds.Min).Div(m.Bounds.Diagonal())
+}
+
+func (m GridMedium) density(p Vector3) float64 {
+	return m.Grid.lookup(m.Grid.density, m.local(p))
+}
+
+func (m GridMedium) emission(p Vector3) Vector3 {
+	if m.Grid.emission == nil || m.Emission.IsZero() {
+		return Vector3Zero
+	}
+	return m.Emission.MulScalar(m.Grid.lookup(m.Grid.emission, m.local(p)))
+}
+
+// maxExtinction returns the largest extinction coefficient of the channels, per unit of density
+func (m GridMedium) maxExtinction() float64 {
+	sigmaT := m.Absorption.Add(m.Scattering)
+	return math.Max(sigmaT.X, math.Max(sigmaT.Y, sigmaT.Z))
+}
+
+// traverse walks through the cells of the majorant grid crossed by the ray, up to tMax,
+// calling f with the range of the ray over each cell and its majorant until f returns false
+func (m GridMedium) traverse(ray Ray, tMax float64, f func(t0, t1, majorant float64) bool) {
+	tEnter, tExit, ok := m.Bounds.IntersectRay(ray)
+	if !ok {
+		return
+	}
+	tExit = math.Min(tExit, tMax)
+	if tEnter >= tExit {
+		return
+	}
+
+	res := m.majorants.resolution
+	size := m.Bounds.Diagonal()
+	start := m.local(ray.Origin.Add(ray.Direction.MulScalar(tEnter)))
+
+	var cell, step [3]int
+	var next, delta [3]float64
+	for a := 0; a < 3; a++ {
+		o := start.Axis(a) * float64(res)
+		d := ray.Direction.Axis(a) / size.Axis(a) * float64(res)
+		cell[a] = int(math.Max(0, math.Min(float64(res-1), math.Floor(o))))
+
+		switch {
+		case d > 0:
+			step[a] = 1
+			next[a] = tEnter + (float64(cell[a]+1)-o)/d
+			delta[a] = 1 / d
+		case d < 0:
+			step[a] = -1
+			next[a] = tEnter + (float64(cell[a])-o)/d
+			delta[a] = -1 / d
+		default:
+			next[a] = math.Inf(1)
+			delta[a] = math.Inf(1)
+		}
+	}
+
+	t := tEnter
+	for {
+		axis := 0
+		if next[1] < next[axis] {
+			axis = 1
+		}
+		if next[2] < next[axis] {
+			axis = 2
+		}
+
+		t1 := math.Min(next[axis], tExit)
+		majorant := m.majorants.values[(cell[2]*res+cell[1])*res+cell[0]]
+		if !f(t, t1, majorant) || t1 >= tExit {
+			return
+		}
+
+		t = t1
+		cell[axis] += step[axis]
+		next[axis] += delta[axis]
+		if cell[axis] < 0 || cell[axis] >= res {
+			return
+		}
+	}
+}
+
+// sampleDistance uses delta tracking: tentative collisions are sampled against the majorant, then turned
+// into absorption, scattering or null collisions according to the densities at their location
+func (m GridMedium) sampleDistance(ray Ray, tMax float64, rnd *rand.Rand) (float64, bool, Vector3, Vector3) {
+	weight := NewVector3(1, 1, 1)
+	emitted := Vector3Zero
+	scatteredAt := -1.
+	absorbed := false
+
+	maxExtinction := m.maxExtinction()
+
+	m.traverse(ray, tMax, func(t0, t1, majorant float64) bool {
+		sigmaMaj := majorant * maxExtinction
+		if sigmaMaj == 0 {
+			return true
+		}
+
+		t := t0
+		for {
+			t -= math.Log(1-rnd.Float64()) / sigmaMaj
+			if t >= t1 {
+				return true
+			}
+
+			p := ray.Origin.Add(ray.Direction.MulScalar(t))
+			density := m.density(p)
+			sigmaA := m.Absorption.MulScalar(density)
+			sigmaS := m.Scattering.MulScalar(density)
+
+			emitted = emitted.Add(weight.Mul(sigmaA).Mul(m.emission(p)).MulScalar(1 / sigmaMaj))
+
+			pAbsorb := average(sigmaA) / sigmaMaj
+			pScatter := average(sigmaS) / sigmaMaj
+			u := rnd.Float64()
+
+			switch {
+			case u < pAbsorb:
+				absorbed = true
+				return false
+			case u < pAbsorb+pScatter:
+				weight = weight.Mul(sigmaS).MulScalar(1 / (sigmaMaj * pScatter))
+				scatteredAt = t
+				return false
+			}
+
+			pNull := 1 - pAbsorb - pScatter
+			if pNull <= 0 {
+				absorbed = true
+				return false
+			}
+
+			sigmaN := NewVector3(sigmaMaj, sigmaMaj, sigmaMaj).Sub(sigmaA).Sub(sigmaS)
+			weight = weight.Mul(sigmaN).MulScalar(1 / (sigmaMaj * pNull))
+		}
+	})
+
+	switch {
+	case absorbed:
+		return tMax, false, Vector3Zero, emitted
+	case scatteredAt >= 0:
+		return scatteredAt, true, weight, emitted
+	}
+
+	return tMax, false, weight, emitted
+}
+
+// transmittance uses ratio tracking, each tentative collision attenuating the light by the probability of a null collision
+func (m GridMedium) transmittance(ray Ray, distance float64, rnd *rand.Rand) Vector3 {
+	tr := NewVector3(1, 1, 1)
+	sigmaT := m.Absorption.Add(m.Scattering)
+	maxExtinction := m.maxExtinction()
+
+	m.traverse(ray, distance, func(t0, t1, majorant float64) bool {
+		sigmaMaj := majorant * maxExtinction
+		if sigmaMaj == 0 {
+			return true
+		}
+
+		t := t0
+		for {
+			t -= math.Log(1-rnd.Float64()) / sigmaMaj
+			if t >= t1 {
+				return true
+			}
+
+			density := m.density(ray.Origin.Add(ray.Direction.MulScalar(t)))
+			null := NewVector3(1, 1, 1).Sub(sigmaT.MulScalar(density / sigmaMaj))
+			tr = tr.Mul(null)
+			if tr.IsZero() {
+				return false
+			}
+		}
+	})
+
+	return tr
+}
+
+func (m GridMedium) phase(wo, wi Vector3) float64 {
+	return henyeyGreenstein(wo.Dot(wi), m.G)
+}
+
+func (m GridMedium) samplePhase(wo Vector3, u1, u2 float64) Vector3 {
+	return sampleHenyeyGreenstein(wo, m.G, u1, u2)
+}
+
+func average(v Vector3) float64 {
+	return (v.X + v.Y + v.Z) / 3
+}